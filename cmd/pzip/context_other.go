@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// handleContextMenu reports that context menu integration needs Windows.
+func handleContextMenu(action string) {
+	fmt.Fprintln(os.Stderr, "Context menu integration is only available on Windows")
+	os.Exit(1)
+}
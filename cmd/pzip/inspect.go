@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MattInnovates/Project-Zipper/internal/zipper"
+)
+
+// subcommands are dispatched on the first argument, unless a file or folder of
+// that name exists here: given alone it is archived, and given with more
+// arguments pz reports the clash. Write ./list to archive it with others.
+var subcommands = map[string]func(args []string){
+	"verify": doVerify,
+	"test":   doTest,
+	"list":   doList,
+	"info":   doInfo,
+	"add":    doAdd,
+	"update": doUpdate,
+	"delete": doDelete,
+}
+
+// archiveArg resolves the single archive argument of a subcommand.
+func archiveArg(name string, args []string) string {
+	if len(args) != 1 {
+		exitWithError(fmt.Errorf("usage: pz %s <archive>", name))
+	}
+	absPath, err := filepath.Abs(args[0])
+	if err != nil {
+		exitWithError(err)
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		exitWithError(err)
+	}
+	if info.IsDir() {
+		exitWithError(errors.New("source must be an archive file, not a directory"))
+	}
+	return absPath
+}
+
+// doVerify checks the checksum stored in a zip comment or .sha256 sidecar.
+func doVerify(args []string) {
+	archivePath := archiveArg("verify", args)
+
+	ok, stored, err := zipper.VerifyChecksum(archivePath)
+	if err != nil {
+		exitWithError(err)
+	}
+	if !ok {
+		fmt.Fprintf(os.Stdout, "✗ Checksum mismatch: %s\n", filepath.Base(archivePath))
+		fmt.Fprintf(os.Stdout, "  Expected SHA-256: %s\n", stored)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "✓ Checksum OK: %s\n", filepath.Base(archivePath))
+	fmt.Fprintf(os.Stdout, "  SHA-256: %s\n", stored)
+}
+
+// doTest decompresses every entry without writing anything to disk.
+func doTest(args []string) {
+	archivePath := archiveArg("test", args)
+
+	start := time.Now()
+	stats, err := zipper.TestArchive(archivePath, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "✗ Test failed: %s\n", filepath.Base(archivePath))
+		exitWithError(err)
+	}
+	fmt.Fprintf(os.Stdout, "✓ Test OK: %s (%s in %d files, %s)\n",
+		filepath.Base(archivePath),
+		formatBytes(stats.TotalBytes),
+		stats.FileCount,
+		formatDuration(time.Since(start)),
+	)
+}
+
+// doList prints the entries of an archive as a table, or one ls -l style
+// line per entry with -l.
+func doList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	long := fs.Bool("l", false, "long form: permissions, exact sizes and full timestamps")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pz list [-l] <archive>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	archivePath := archiveArg("list", fs.Args())
+
+	entries, err := zipper.ListArchive(archivePath)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if *long {
+		for _, e := range entries {
+			compressed := "-"
+			if e.CompressedSize >= 0 {
+				compressed = fmt.Sprint(e.CompressedSize)
+			}
+			fmt.Fprintf(os.Stdout, "%s %12d %12s %-8s %s %s\n",
+				e.Mode, e.Size, compressed, e.Method, e.Modified.Local().Format(time.RFC3339), e.Name)
+		}
+		return
+	}
+
+	var totalSize, totalCompressed int64
+	files := 0
+	fmt.Fprintf(os.Stdout, "%10s  %10s  %-8s  %-16s  %s\n", "Size", "Compressed", "Method", "Modified", "Name")
+	for _, e := range entries {
+		compressed := "-"
+		if e.CompressedSize >= 0 {
+			compressed = formatBytes(e.CompressedSize)
+			totalCompressed += e.CompressedSize
+		}
+		if !e.IsDir() {
+			totalSize += e.Size
+			files++
+		}
+		fmt.Fprintf(os.Stdout, "%10s  %10s  %-8s  %-16s  %s\n",
+			formatBytes(e.Size), compressed, e.Method, e.Modified.Local().Format("2006-01-02 15:04"), e.Name)
+	}
+
+	compressed := "-"
+	if totalCompressed > 0 {
+		compressed = formatBytes(totalCompressed)
+	}
+	fmt.Fprintf(os.Stdout, "%10s  %10s  %d files, %d entries\n", formatBytes(totalSize), compressed, files, len(entries))
+}
+
+// doInfo reports the format of an archive as detected from its content and,
+// for formats that can be read, a summary of its entries.
+func doInfo(args []string) {
+	archivePath := archiveArg("info", args)
+
+	info, err := zipper.DetectArchive(archivePath)
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Fprintf(os.Stdout, "File:    %s\n", archivePath)
+	fmt.Fprintf(os.Stdout, "Format:  %s\n", info.Name())
+	fmt.Fprintf(os.Stdout, "Size:    %s\n", formatBytes(info.Size))
+
+	entries, err := zipper.ListArchive(archivePath)
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "Entries: unavailable (%v)\n", err)
+		return
+	}
+	var totalSize int64
+	files := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			totalSize += e.Size
+			files++
+		}
+	}
+	fmt.Fprintf(os.Stdout, "Entries: %d (%d files, %s uncompressed)\n", len(entries), files, formatBytes(totalSize))
+}
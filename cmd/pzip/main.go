@@ -5,29 +5,84 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/MattInnovates/Project-Zipper/internal/zipper"
-	"golang.org/x/sys/windows/registry"
 )
 
 func main() {
 	extractFlag := flag.Bool("x", false, "extract mode: extract archive to destination")
-	formatFlag := flag.String("f", "zip", "archive format: zip or gz (tar.gz)")
+	formatFlag := flag.String("f", "zip", "archive format: zip, gz (tar.gz, or .gz of a single file), tar.gz, zst (tar.zst) or tar")
 	contextFlag := flag.String("context", "", "install/uninstall Windows context menu: install, uninstall, or status")
+	outputFlag := flag.String("o", "", "create mode: write the archive to exactly this `file`")
+	outputDirFlag := flag.String("d", "", "create mode: write the automatically named archive into this `directory`")
+	ifExistsFlag := flag.String("if-exists", "error", "create mode: when the -o file exists: error, overwrite, or version")
+	memFlag := flag.String("mem", "", "memory budget for reading files ahead, e.g. 512MB or 2GB (default 256MB)")
+	var excludeFlag, includeFlag stringList
+	flag.Var(&excludeFlag, "exclude", "exclude files matching `pattern` (repeatable); in extract mode, skip matching entries")
+	flag.Var(&includeFlag, "include", "keep files matching `pattern` even if excluded (repeatable); in extract mode, extract only matching entries")
+	noDefaultExcludesFlag := flag.Bool("no-default-excludes", false, "do not skip hidden, dependency, build and temp files by default")
+	noIgnoreFilesFlag := flag.Bool("no-ignore-files", false, "do not honor .gitignore and .pzignore files")
+	symlinksFlag := flag.String("symlinks", "store", "create mode: symlinks inside sources: store, follow, or skip")
+	dedupFlag := flag.Bool("dedup-hardlinks", false, "create mode: store repeated hard links in a zip as symlinks to the first copy")
+	xattrsFlag := flag.Bool("xattrs", false, "create mode: store extended attributes and POSIX ACLs in tar.gz archives (Linux)")
+	noPreserveFlag := flag.Bool("no-preserve", false, "extract mode: do not restore modification times, permissions and ownership")
+	maxSizeFlag := flag.String("max-size", "", "extract mode: abort if more than this many bytes would be written, e.g. 10GB")
+	maxFileSizeFlag := flag.String("max-file-size", "", "extract mode: abort if a single file is larger than this, e.g. 1GB")
+	maxEntriesFlag := flag.Int("max-entries", 0, "extract mode: abort if the archive has more entries than this")
+	maxRatioFlag := flag.Float64("max-ratio", 0, "extract mode: abort if data expands more than this many times, e.g. 100")
+	maxDepthFlag := flag.Int("max-depth", 0, "extract mode: abort if an entry is nested deeper than this many path elements")
+	stripFlag := flag.Int("strip-components", 0, "extract mode: remove this many leading path elements from entry names")
+	var renameFlag stringList
+	flag.Var(&renameFlag, "rename", "extract mode: write entries starting with old under new, as `old=new` (repeatable)")
+	overwriteFlag := flag.String("overwrite", "overwrite", "extract mode: when a file exists: overwrite, skip-existing, keep-newer, rename-new, or fail")
+	dryRunFlag := flag.Bool("dry-run", false, "extract mode: report what would be written and overwritten without writing anything")
+	atomicFlag := flag.Bool("atomic", false, "extract mode: extract into a staging folder and swap it into place, keeping the old destination as a backup")
+	reproducibleFlag := flag.Bool("reproducible", false, "create mode: byte-identical output for identical input (timestamps from SOURCE_DATE_EPOCH)")
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <source> [destination]\n", filepath.Base(os.Args[0]))
+		name := filepath.Base(os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [--] <source>... | -x <archive> [destination] [-- <pattern>...]\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s verify|test|list|info <archive>\n", name)
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s add|update|delete <archive.zip> <paths or patterns>...\n", name)
 		fmt.Fprintln(flag.CommandLine.Output(), "\nCreate or extract archives.")
 		fmt.Fprintln(flag.CommandLine.Output(), "CREATE MODE (default):")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz <folder>           Create a zip archive of the folder")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz <file|folder>...   Create a zip archive of several files and folders")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -o out.zip <path>  Write the archive to out.zip")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -d D:\\Backups <path>  Write the archive into another folder")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz -f gz <folder>     Create a tar.gz archive of the folder")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -f gz <file>       Compress a single file to <file>.gz")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -f zst <folder>    Create a tar.zst archive of the folder")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -f tar <folder>    Create an uncompressed tar archive of the folder")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -mem 1GB <folder>  Limit the memory used for reading files ahead")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz --exclude '*.log' --include .env <folder>  Adjust which files are skipped")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz --reproducible <folder>  Create the same archive bytes on every run")
 		fmt.Fprintln(flag.CommandLine.Output(), "\nEXTRACT MODE:")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x <archive.zip>   Extract archive to current directory")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x <archive.tar.gz> <dest>  Extract archive to destination folder")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x <file.gz>       Decompress a single gzipped file")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x <archive> <dest> -- 'config/**' '*.json'  Extract only matching entries")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x --exclude '*.log' <archive>  Skip matching entries")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x --strip-components 1 <archive>  Drop the top-level folder of every entry")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x --overwrite keep-newer <archive> <dest>  Keep existing files that are newer")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x --dry-run <archive> <dest>  Show which existing files would be overwritten")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x --atomic <archive> <dest>  Replace dest only once everything extracted, keeping a backup")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x --rename 'docs/=manual/' <archive>  Extract entries under docs/ into manual/")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x --no-preserve <archive>  Do not restore times, permissions and owners")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz -x --max-size 10GB --max-ratio 100 <archive>  Refuse zip bombs")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nINSPECT:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz verify <archive>   Check the archive against its stored SHA-256 checksum")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz test <archive>     Decompress every entry and check CRCs without writing files")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz list [-l] <archive>  List entries with sizes, methods and timestamps")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz info <archive>     Show the format detected from the file's content")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nMODIFY (zip):")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz add <archive.zip> <path>...  Add or replace files, named relative to the current folder")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz update <archive.zip> <folder>  Replace entries whose files in the folder are newer, add new files")
+		fmt.Fprintln(flag.CommandLine.Output(), "  pz delete <archive.zip> <pattern>...  Remove entries matching glob patterns")
 		fmt.Fprintln(flag.CommandLine.Output(), "\nCONTEXT MENU (Windows):")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz --context install    Add 'Compress with pz' to Windows context menu")
 		fmt.Fprintln(flag.CommandLine.Output(), "  pz --context uninstall  Remove from Windows context menu")
@@ -47,75 +102,276 @@ func main() {
 		os.Exit(2)
 	}
 
+	if run, ok := subcommands[flag.Arg(0)]; ok && !*extractFlag {
+		// A file or folder named like a command is archived when given on its
+		// own, since every command needs more arguments
+		_, err := os.Lstat(flag.Arg(0))
+		switch {
+		case err != nil:
+			run(flag.Args()[1:])
+			return
+		case flag.NArg() > 1:
+			exitWithError(fmt.Errorf("%q is both a command and a file or folder here; write it as ./%s to archive it, or run the command from another folder", flag.Arg(0), flag.Arg(0)))
+		}
+	}
+
 	if *extractFlag {
-		doExtract(flag.Args())
+		maxSize, err := parseSize(*maxSizeFlag)
+		if err != nil {
+			exitWithError(fmt.Errorf("invalid --max-size value: %w", err))
+		}
+		maxFileSize, err := parseSize(*maxFileSizeFlag)
+		if err != nil {
+			exitWithError(fmt.Errorf("invalid --max-file-size value: %w", err))
+		}
+		if *stripFlag < 0 {
+			exitWithError(fmt.Errorf("invalid --strip-components value: %d", *stripFlag))
+		}
+		overwrite, err := zipper.ParseOverwritePolicy(*overwriteFlag)
+		if err != nil {
+			exitWithError(err)
+		}
+		var renames []zipper.RenameRule
+		for _, r := range renameFlag {
+			rule, err := zipper.ParseRenameRule(r)
+			if err != nil {
+				exitWithError(err)
+			}
+			renames = append(renames, rule)
+		}
+		doExtract(flag.Args(), zipper.ExtractOptions{
+			NoPreserve:      *noPreserveFlag,
+			Include:         includeFlag,
+			Exclude:         excludeFlag,
+			StripComponents: *stripFlag,
+			Rename:          renames,
+			Overwrite:       overwrite,
+			DryRun:          *dryRunFlag,
+			Atomic:          *atomicFlag,
+			Limits: zipper.ExtractLimits{
+				MaxTotalBytes: maxSize,
+				MaxEntries:    *maxEntriesFlag,
+				MaxFileSize:   maxFileSize,
+				MaxRatio:      *maxRatioFlag,
+				MaxDepth:      *maxDepthFlag,
+			},
+		})
 	} else {
-		doCreate(flag.Args(), *formatFlag)
+		memLimit, err := parseSize(*memFlag)
+		if err != nil {
+			exitWithError(fmt.Errorf("invalid -mem value: %w", err))
+		}
+		sourceDate, err := sourceDateEpoch()
+		if err != nil {
+			exitWithError(err)
+		}
+		symlinks, err := zipper.ParseSymlinkPolicy(*symlinksFlag)
+		if err != nil {
+			exitWithError(err)
+		}
+		output := outputOptions{file: *outputFlag, dir: *outputDirFlag, ifExists: *ifExistsFlag}
+		doCreate(flag.Args(), *formatFlag, output, zipper.CreateOptions{
+			MemoryLimit:    memLimit,
+			Symlinks:       symlinks,
+			DedupHardLinks: *dedupFlag,
+			Xattrs:         *xattrsFlag,
+			Reproducible:   *reproducibleFlag,
+			SourceDate:     sourceDate,
+			Exclude: zipper.ExcludePolicy{
+				NoDefaults:    *noDefaultExcludesFlag,
+				NoIgnoreFiles: *noIgnoreFilesFlag,
+				Exclude:       excludeFlag,
+				Include:       includeFlag,
+			},
+		})
 	}
 }
 
-func doCreate(args []string, format string) {
-	target := strings.Join(args, " ")
-	absTarget, err := filepath.Abs(target)
+func doCreate(args []string, format string, output outputOptions, opts zipper.CreateOptions) {
+	// flag.Parse stops at the first source, so a "--" between sources is
+	// still in args
+	for i, arg := range args {
+		if arg == "--" {
+			args = append(args[:i:i], args[i+1:]...)
+			break
+		}
+	}
+	if len(args) < 1 {
+		exitWithError(errors.New("create mode requires a source file or folder"))
+	}
+
+	sources := make([]string, 0, len(args))
+	for _, arg := range args {
+		absSource, err := filepath.Abs(arg)
+		if err != nil {
+			exitWithError(err)
+		}
+		if _, err := os.Stat(absSource); err != nil {
+			exitWithError(err)
+		}
+		sources = append(sources, absSource)
+	}
+
+	var nextName func(dir, base string) (string, error)
+	var create func(sources []string, archivePath string, opts zipper.CreateOptions) (zipper.ArchiveStats, error)
+	switch strings.ToLower(format) {
+	case "gz", "gzip":
+		if isSingleFile(sources) {
+			// Compress the file itself, the way gzip does: file.log -> file.log.gz
+			nextName = func(dir, _ string) (string, error) {
+				return zipper.NextGzipFileName(dir, filepath.Base(sources[0]))
+			}
+			create = func(sources []string, archivePath string, opts zipper.CreateOptions) (zipper.ArchiveStats, error) {
+				return zipper.CompressGzip(sources[0], archivePath, opts)
+			}
+			break
+		}
+		nextName, create = zipper.NextGzipArchiveName, zipper.GzipFiles
+	case "tar.gz", "tgz":
+		nextName, create = zipper.NextGzipArchiveName, zipper.GzipFiles
+	case "zst", "zstd", "tar.zst":
+		nextName, create = zipper.NextZstdArchiveName, zipper.ZstdFiles
+	case "tar":
+		nextName, create = zipper.NextTarArchiveName, zipper.TarFiles
+	case "zip":
+		nextName, create = zipper.NextArchiveName, zipper.ZipFiles
+	default:
+		exitWithError(fmt.Errorf("unsupported format: %s (use 'zip', 'gz', 'zst' or 'tar')", format))
+	}
+
+	archivePath, err := output.resolve(sources, nextName)
 	if err != nil {
 		exitWithError(err)
 	}
 
-	info, err := os.Stat(absTarget)
+	printer := newCreateProgressPrinter(describeSources(sources))
+	opts.Progress = printer.OnProgressWithFile
+
+	stats, err := create(sources, archivePath, opts)
 	if err != nil {
 		exitWithError(err)
 	}
-	if !info.IsDir() {
-		exitWithError(errors.New("target must be a directory"))
-	}
 
-	parent := filepath.Dir(absTarget)
-	base := filepath.Base(absTarget)
+	printer.Complete(archivePath, stats)
+	fmt.Println(archivePath)
+}
 
-	var archivePath string
-	var stats zipper.ArchiveStats
+// outputOptions says where create mode writes the archive.
+type outputOptions struct {
+	file     string // -o: exact archive path
+	dir      string // -d: directory for an automatically named archive
+	ifExists string // what to do when -o names an existing file
+}
 
-	printer := newCreateProgressPrinter(absTarget)
+// resolve returns the archive path for sources. Without -o the archive is
+// named after the sources and versioned (name-v1.zip, ...) in the -d
+// directory or next to the sources.
+func (o outputOptions) resolve(sources []string, nextName func(dir, base string) (string, error)) (string, error) {
+	if o.file != "" && o.dir != "" {
+		return "", errors.New("-o and -d cannot be used together")
+	}
 
-	switch strings.ToLower(format) {
-	case "gz", "gzip", "tar.gz":
-		archivePath, err = zipper.NextGzipArchiveName(parent, base)
-		if err != nil {
-			exitWithError(err)
-		}
-		stats, err = zipper.GzipWithProgressAndFile(absTarget, archivePath, printer.OnProgressWithFile)
-		if err != nil {
-			exitWithError(err)
-		}
-	case "zip":
-		archivePath, err = zipper.NextArchiveName(parent, base)
-		if err != nil {
-			exitWithError(err)
-		}
-		stats, err = zipper.ZipWithProgressAndFile(absTarget, archivePath, printer.OnProgressWithFile)
-		if err != nil {
-			exitWithError(err)
+	if o.file == "" {
+		dir, base := archiveBaseName(sources)
+		if o.dir != "" {
+			absDir, err := filepath.Abs(o.dir)
+			if err != nil {
+				return "", err
+			}
+			if err := os.MkdirAll(absDir, 0755); err != nil {
+				return "", err
+			}
+			dir = absDir
 		}
+		return nextName(dir, base)
+	}
+
+	archivePath, err := filepath.Abs(o.file)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(archivePath)
+	if os.IsNotExist(err) {
+		return archivePath, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory (use -d to choose an output directory)", archivePath)
+	}
+
+	switch strings.ToLower(o.ifExists) {
+	case "", "error":
+		return "", fmt.Errorf("%s already exists (use --if-exists overwrite or version)", archivePath)
+	case "overwrite":
+		return archivePath, nil
+	case "version":
+		return zipper.NextAvailableName(archivePath)
 	default:
-		exitWithError(fmt.Errorf("unsupported format: %s (use 'zip' or 'gz')", format))
+		return "", fmt.Errorf("unknown --if-exists policy: %s (use error, overwrite or version)", o.ifExists)
 	}
+}
 
-	printer.Complete(archivePath, stats)
-	fmt.Println(archivePath)
+// archiveBaseName picks the directory and base name of the archive for
+// sources. A single folder or file names the archive after itself (without
+// the file's extension) next to it; several sources name it after the
+// folder that holds the first one.
+func archiveBaseName(sources []string) (dir, base string) {
+	first := sources[0]
+	dir = filepath.Dir(first)
+	if len(sources) == 1 {
+		base = filepath.Base(first)
+		if info, err := os.Stat(first); err == nil && !info.IsDir() {
+			if ext := filepath.Ext(base); ext != "" && ext != base {
+				base = strings.TrimSuffix(base, ext)
+			}
+		}
+		return dir, base
+	}
+
+	base = filepath.Base(dir)
+	if base == "." || base == string(filepath.Separator) {
+		base = "archive"
+	}
+	return dir, base
 }
 
-func doExtract(args []string) {
+// isSingleFile reports whether sources is one regular file.
+func isSingleFile(sources []string) bool {
+	if len(sources) != 1 {
+		return false
+	}
+	info, err := os.Stat(sources[0])
+	return err == nil && info.Mode().IsRegular()
+}
+
+// describeSources returns a short label for sources in progress output.
+func describeSources(sources []string) string {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+	return fmt.Sprintf("%s and %d more", sources[0], len(sources)-1)
+}
+
+func doExtract(args []string, opts zipper.ExtractOptions) {
+	// Patterns after "--" select the entries to extract, along with any
+	// given with --include. flag.Parse stops at the archive, so the "--" is
+	// still in args.
+	for i, arg := range args {
+		if arg == "--" {
+			args, opts.Include = args[:i], append(opts.Include, args[i+1:]...)
+			break
+		}
+	}
 	if len(args) < 1 {
 		exitWithError(errors.New("extract mode requires an archive file"))
 	}
-
-	archivePath := strings.Join(args, " ")
-	if len(args) > 1 {
-		// If multiple args, first is archive, rest is destination
-		archivePath = args[0]
+	if len(args) > 2 {
+		exitWithError(errors.New("usage: pz -x <archive> [destination] [-- <pattern>...]"))
 	}
 
-	absArchivePath, err := filepath.Abs(archivePath)
+	absArchivePath, err := filepath.Abs(args[0])
 	if err != nil {
 		exitWithError(err)
 	}
@@ -130,8 +386,12 @@ func doExtract(args []string) {
 
 	// Determine destination
 	var destDir string
+	if opts.Atomic && len(args) < 2 {
+		// Swapping out the current directory would pull it from under the shell
+		exitWithError(errors.New("--atomic needs an explicit destination folder"))
+	}
 	if len(args) > 1 {
-		destDir = strings.Join(args[1:], " ")
+		destDir = args[1]
 	} else {
 		// Extract to current directory
 		destDir, err = os.Getwd()
@@ -145,28 +405,73 @@ func doExtract(args []string) {
 		exitWithError(err)
 	}
 
-	printer := newExtractProgressPrinter(absArchivePath, absDestDir)
-
-	// Auto-detect format based on file extension
-	var stats zipper.ExtractStats
-	if strings.HasSuffix(strings.ToLower(absArchivePath), ".tar.gz") || strings.HasSuffix(strings.ToLower(absArchivePath), ".tgz") {
-		stats, err = zipper.ExtractGzipWithProgress(absArchivePath, absDestDir, printer.OnProgress)
-	} else if strings.HasSuffix(strings.ToLower(absArchivePath), ".gz") {
-		// Check if it's a tar.gz by trying to open as such
-		stats, err = zipper.ExtractGzipWithProgress(absArchivePath, absDestDir, printer.OnProgress)
-	} else {
-		// Default to zip
-		stats, err = zipper.ExtractWithProgress(absArchivePath, absDestDir, printer.OnProgress)
+	if opts.DryRun {
+		stats, err := zipper.ExtractArchive(absArchivePath, absDestDir, opts)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Fprintf(os.Stdout, "Dry run: %s -> %s (%s in %d files)\n", filepath.Base(absArchivePath), absDestDir, formatBytes(stats.TotalBytes), stats.FileCount)
+		for _, c := range stats.Conflicts {
+			name := relativeTo(absDestDir, c.Path)
+			switch c.Action {
+			case zipper.ConflictSkipped:
+				fmt.Fprintf(os.Stdout, "  would keep       %s\n", name)
+			case zipper.ConflictRenamed:
+				fmt.Fprintf(os.Stdout, "  would rename     %s -> %s\n", name, relativeTo(absDestDir, c.NewPath))
+			default:
+				fmt.Fprintf(os.Stdout, "  would overwrite  %s\n", name)
+			}
+		}
+		if len(stats.Conflicts) == 0 {
+			fmt.Fprintln(os.Stdout, "  no existing files would be touched")
+		}
+		return
 	}
 
+	printer := newExtractProgressPrinter(absArchivePath, absDestDir)
+	opts.Progress = printer.OnProgress
+
+	// The format is recognised by content, whatever the file is called
+	stats, err := zipper.ExtractArchive(absArchivePath, absDestDir, opts)
 	if err != nil {
 		exitWithError(err)
 	}
 	printer.Complete(stats)
+	if len(stats.Conflicts) > 0 {
+		counts := make(map[zipper.ConflictAction]int)
+		for _, c := range stats.Conflicts {
+			counts[c.Action]++
+		}
+		fmt.Fprintf(os.Stdout, "  Existing files: %d overwritten, %d kept, %d renamed\n",
+			counts[zipper.ConflictOverwritten], counts[zipper.ConflictSkipped], counts[zipper.ConflictRenamed])
+	}
+	if stats.Backup != "" {
+		fmt.Fprintf(os.Stdout, "  Previous contents moved to %s\n", stats.Backup)
+	}
 
 	fmt.Println(absDestDir)
 }
 
+// relativeTo returns path relative to dir, or path itself if it is not inside.
+func relativeTo(dir, path string) string {
+	if rel, err := filepath.Rel(dir, path); err == nil && filepath.IsLocal(rel) {
+		return rel
+	}
+	return path
+}
+
+// stringList is a flag.Value that collects every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func exitWithError(err error) {
 	fmt.Fprintln(os.Stderr, "pz:", err)
 	os.Exit(1)
@@ -414,6 +719,52 @@ func formatBytes(n int64) string {
 	return fmt.Sprintf("%.1f %s", value, suffixes[exp])
 }
 
+// parseSize parses a byte count such as "512MB", "2G" or "1048576".
+// An empty string yields zero.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	multipliers := []struct {
+		suffix string
+		value  int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+	multiplier := int64(1)
+	for _, m := range multipliers {
+		if strings.HasSuffix(s, m.suffix) {
+			multiplier = m.value
+			s = strings.TrimSpace(strings.TrimSuffix(s, m.suffix))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("not a size: %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// sourceDateEpoch reads the SOURCE_DATE_EPOCH environment variable used by
+// reproducible builds. It yields the zero time when the variable is unset.
+func sourceDateEpoch() (time.Time, error) {
+	value := strings.TrimSpace(os.Getenv("SOURCE_DATE_EPOCH"))
+	if value == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %q", value)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
 func formatDuration(d time.Duration) string {
 	if d < time.Second {
 		return fmt.Sprintf("%dms", d.Milliseconds())
@@ -431,198 +782,3 @@ func formatDuration(d time.Duration) string {
 	minutes = minutes % 60
 	return fmt.Sprintf("%dh%dm", hours, minutes)
 }
-
-// handleContextMenu manages Windows context menu integration
-func handleContextMenu(action string) {
-	if runtime.GOOS != "windows" {
-		fmt.Fprintln(os.Stderr, "Context menu integration is only available on Windows")
-		os.Exit(1)
-	}
-
-	switch strings.ToLower(action) {
-	case "install":
-		if err := installContextMenu(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to install context menu: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Println("✓ Context menu installed successfully!")
-		fmt.Println("Right-click any folder or file and look for 'Compress with pz' options")
-	case "uninstall":
-		if err := uninstallContextMenu(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to uninstall context menu: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Println("✓ Context menu uninstalled successfully!")
-	case "status":
-		checkContextMenuStatus()
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown action: %s (use install, uninstall, or status)\n", action)
-		os.Exit(1)
-	}
-}
-
-// installContextMenu adds registry entries for Windows Explorer context menu
-func installContextMenu() error {
-	exePath, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("cannot get executable path: %w", err)
-	}
-
-	// Check if running as administrator
-	if !isAdmin() {
-		fmt.Println("⚠ Administrator privileges required for context menu installation")
-		fmt.Println("Attempting to restart with administrator privileges...")
-		return runAsAdmin("--context", "install")
-	}
-
-	// Directory background context menu (right-click in folder)
-	keys := []struct {
-		path    string
-		command string
-		name    string
-	}{
-		{
-			path:    `Directory\\shell\\pz_zip`,
-			command: fmt.Sprintf(`"%s" "%%V"`, exePath),
-			name:    "Compress to ZIP",
-		},
-		{
-			path:    `Directory\\shell\\pz_targz`,
-			command: fmt.Sprintf(`"%s" -f gz "%%V"`, exePath),
-			name:    "Compress to tar.gz",
-		},
-		{
-			path:    `Directory\\Background\\shell\\pz_zip`,
-			command: fmt.Sprintf(`"%s" "%%V"`, exePath),
-			name:    "Compress folder to ZIP",
-		},
-		{
-			path:    `Directory\\Background\\shell\\pz_targz`,
-			command: fmt.Sprintf(`"%s" -f gz "%%V"`, exePath),
-			name:    "Compress folder to tar.gz",
-		},
-		{
-			path:    `*\\shell\\pz_zip`,
-			command: fmt.Sprintf(`"%s" "%%1"`, exePath),
-			name:    "Compress to ZIP",
-		},
-		{
-			path:    `*\\shell\\pz_extract`,
-			command: fmt.Sprintf(`"%s" -x "%%1"`, exePath),
-			name:    "Extract here",
-		},
-	}
-
-	for _, k := range keys {
-		key, _, err := registry.CreateKey(registry.CLASSES_ROOT, k.path, registry.SET_VALUE)
-		if err != nil {
-			return fmt.Errorf("failed to create key %s: %w", k.path, err)
-		}
-		if err := key.SetStringValue("", k.name); err != nil {
-			key.Close()
-			return fmt.Errorf("failed to set name for %s: %w", k.path, err)
-		}
-		key.Close()
-
-		// Set icon
-		iconKey, _, err := registry.CreateKey(registry.CLASSES_ROOT, k.path, registry.SET_VALUE)
-		if err == nil {
-			iconKey.SetStringValue("Icon", exePath+",0")
-			iconKey.Close()
-		}
-
-		// Create command subkey
-		cmdKey, _, err := registry.CreateKey(registry.CLASSES_ROOT, k.path+`\\command`, registry.SET_VALUE)
-		if err != nil {
-			return fmt.Errorf("failed to create command key for %s: %w", k.path, err)
-		}
-		if err := cmdKey.SetStringValue("", k.command); err != nil {
-			cmdKey.Close()
-			return fmt.Errorf("failed to set command for %s: %w", k.path, err)
-		}
-		cmdKey.Close()
-	}
-
-	return nil
-}
-
-// uninstallContextMenu removes registry entries
-func uninstallContextMenu() error {
-	// Check if running as administrator
-	if !isAdmin() {
-		fmt.Println("⚠ Administrator privileges required for context menu uninstallation")
-		fmt.Println("Attempting to restart with administrator privileges...")
-		return runAsAdmin("--context", "uninstall")
-	}
-
-	keys := []string{
-		`Directory\\shell\\pz_zip`,
-		`Directory\\shell\\pz_targz`,
-		`Directory\\Background\\shell\\pz_zip`,
-		`Directory\\Background\\shell\\pz_targz`,
-		`*\\shell\\pz_zip`,
-		`*\\shell\\pz_extract`,
-	}
-
-	var errors []string
-	for _, k := range keys {
-		if err := registry.DeleteKey(registry.CLASSES_ROOT, k); err != nil {
-			if err != registry.ErrNotExist {
-				errors = append(errors, fmt.Sprintf("%s: %v", k, err))
-			}
-		}
-	}
-
-	if len(errors) > 0 {
-		return fmt.Errorf("some keys could not be removed:\\n%s", strings.Join(errors, "\\n"))
-	}
-
-	return nil
-}
-
-// checkContextMenuStatus checks if context menu is installed
-func checkContextMenuStatus() {
-	key, err := registry.OpenKey(registry.CLASSES_ROOT, `Directory\\shell\\pz_zip`, registry.QUERY_VALUE)
-	if err == nil {
-		key.Close()
-		fmt.Println("✓ Context menu is installed")
-
-		exePath, _ := os.Executable()
-		cmdKey, err := registry.OpenKey(registry.CLASSES_ROOT, `Directory\\shell\\pz_zip\\command`, registry.QUERY_VALUE)
-		if err == nil {
-			cmd, _, _ := cmdKey.GetStringValue("")
-			cmdKey.Close()
-			fmt.Printf("  Executable: %s\n", exePath)
-			fmt.Printf("  Command: %s\n", cmd)
-		}
-	} else {
-		fmt.Println("✗ Context menu is not installed")
-		fmt.Println("  Run: pz --context install")
-	}
-}
-
-// isAdmin checks if the current process has administrator privileges
-func isAdmin() bool {
-	_, err := os.Open("\\\\.\\PHYSICALDRIVE0")
-	return err == nil
-}
-
-// runAsAdmin restarts the program with administrator privileges
-func runAsAdmin(args ...string) error {
-	exePath, err := os.Executable()
-	if err != nil {
-		return err
-	}
-
-	verb := "runas"
-	cmd := exec.Command("powershell", "-Command", "Start-Process", "-Verb", verb, "-FilePath", exePath, "-ArgumentList", strings.Join(args, ","), "-Wait")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to elevate privileges: %w", err)
-	}
-
-	os.Exit(0)
-	return nil
-}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MattInnovates/Project-Zipper/internal/zipper"
+)
+
+// editArgs resolves the archive argument of add, update and delete, and
+// checks that at least one more argument follows it.
+func editArgs(name, usage string, args []string) (string, []string) {
+	if len(args) < 2 {
+		exitWithError(fmt.Errorf("usage: pz %s %s", name, usage))
+	}
+	archivePath := archiveArg(name, args[:1])
+	return archivePath, args[1:]
+}
+
+// doAdd adds files and folders to a zip, named by their path relative to the
+// current directory.
+func doAdd(args []string) {
+	archivePath, paths := editArgs("add", "<archive.zip> <path>...", args)
+	baseDir, err := os.Getwd()
+	if err != nil {
+		exitWithError(err)
+	}
+
+	printer := newCreateProgressPrinter(describeSources(paths))
+	stats, err := zipper.AddToZip(archivePath, baseDir, paths, zipper.CreateOptions{Progress: printer.OnProgressWithFile})
+	if err != nil {
+		exitWithError(err)
+	}
+	printEditStats(archivePath, stats, printer)
+}
+
+// doUpdate refreshes a zip with the newer files of the folder it was created from.
+func doUpdate(args []string) {
+	archivePath, rest := editArgs("update", "<archive.zip> <folder>", args)
+	if len(rest) != 1 {
+		exitWithError(errors.New("usage: pz update <archive.zip> <folder>"))
+	}
+	srcDir, err := filepath.Abs(rest[0])
+	if err != nil {
+		exitWithError(err)
+	}
+	if info, err := os.Stat(srcDir); err != nil {
+		exitWithError(err)
+	} else if !info.IsDir() {
+		exitWithError(fmt.Errorf("%s is not a folder", srcDir))
+	}
+
+	printer := newCreateProgressPrinter(srcDir)
+	stats, err := zipper.UpdateZip(archivePath, srcDir, zipper.CreateOptions{Progress: printer.OnProgressWithFile})
+	if err != nil {
+		exitWithError(err)
+	}
+	printEditStats(archivePath, stats, printer)
+}
+
+// doDelete removes the entries matching any of the given globs.
+func doDelete(args []string) {
+	archivePath, patterns := editArgs("delete", "<archive.zip> <pattern>...", args)
+
+	stats, err := zipper.DeleteFromZip(archivePath, patterns)
+	if err != nil {
+		exitWithError(err)
+	}
+	printEditStats(archivePath, stats, nil)
+}
+
+// printEditStats summarizes an edit. printer is the progress printer used
+// while files were added, if any.
+func printEditStats(archivePath string, stats zipper.EditStats, printer *createProgressPrinter) {
+	if printer != nil && printer.started {
+		fmt.Print("\n")
+	}
+	if stats.Checksum == "" {
+		fmt.Fprintf(os.Stdout, "Nothing to change in %s\n", filepath.Base(archivePath))
+		return
+	}
+	fmt.Fprintf(os.Stdout, "✓ Updated %s: %d added, %d replaced, %d deleted, %d unchanged\n",
+		filepath.Base(archivePath), stats.Added, stats.Replaced, stats.Deleted, stats.Kept)
+	fmt.Fprintf(os.Stdout, "  SHA-256: %s\n", stats.Checksum)
+}
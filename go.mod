@@ -2,4 +2,8 @@ module github.com/MattInnovates/Project-Zipper
 
 go 1.24.0
 
-require golang.org/x/sys v0.39.0
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.15
+	golang.org/x/sys v0.39.0
+)
@@ -0,0 +1,151 @@
+package zipper
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// extractAtomic runs extract into a staging directory next to destDir and
+// then renames it to destDir. An existing destDir is first renamed to a
+// backup, which is kept. If anything fails, destDir is left as it was.
+//
+// The two renames are not one atomic step: in between, destDir briefly does
+// not exist. A dry run reports on destDir itself, since nothing is written.
+func extractAtomic(destDir string, opts ExtractOptions, extract func(destDir string, opts ExtractOptions) (ExtractStats, error)) (stats ExtractStats, err error) {
+	opts.Atomic = false
+	if opts.DryRun {
+		return extract(destDir, opts)
+	}
+	if err := checkAtomicPolicy(opts.Overwrite); err != nil {
+		return stats, err
+	}
+
+	destDir, err = filepath.Abs(destDir)
+	if err != nil {
+		return stats, err
+	}
+	existing, err := os.Lstat(destDir)
+	exists := err == nil
+	if exists && !existing.IsDir() {
+		return stats, fmt.Errorf("%s is not a directory", destDir)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return stats, err
+	}
+
+	out := &outputTracker{}
+	defer func() {
+		if err != nil {
+			out.cleanup()
+		}
+	}()
+	if err := out.mkdirAll(filepath.Dir(destDir)); err != nil {
+		return stats, err
+	}
+	stage, err := os.MkdirTemp(filepath.Dir(destDir), "."+filepath.Base(destDir)+".pz-staging-*")
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(stage)
+		}
+	}()
+
+	// MkdirTemp creates the directory private to its owner. A "./" entry
+	// in the archive still gets the last word.
+	mode := fs.FileMode(0755)
+	if exists {
+		mode = existing.Mode().Perm()
+	}
+	if err := os.Chmod(stage, mode); err != nil {
+		return stats, err
+	}
+
+	// Entries are checked as they are extracted: zip CRCs, and the
+	// checksums of compressed streams
+	stats, err = extract(stage, opts)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Backup, err = swapIntoPlace(stage, destDir, exists)
+	return stats, err
+}
+
+// checkAtomicPolicy reports an error if policy cannot be combined with
+// atomic extraction, which replaces its destination as a whole.
+func checkAtomicPolicy(policy OverwritePolicy) error {
+	if policy != OverwriteReplace {
+		return fmt.Errorf("atomic extraction replaces the destination as a whole and cannot be combined with the %s policy", policy)
+	}
+	return nil
+}
+
+// swapIntoPlace renames staged to dest. If dest exists, it is first renamed
+// to a backup, whose name is returned, and put back if staged cannot be
+// moved into its place.
+func swapIntoPlace(staged, dest string, exists bool) (backup string, err error) {
+	if exists {
+		if backup, err = backupName(dest); err != nil {
+			return "", err
+		}
+		if err := os.Rename(dest, backup); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(staged, dest); err != nil {
+		if backup != "" {
+			// Put the original back
+			if restoreErr := os.Rename(backup, dest); restoreErr != nil {
+				return "", fmt.Errorf("%w (the original is kept in %s)", err, backup)
+			}
+		}
+		return "", err
+	}
+	return backup, nil
+}
+
+// createStaged creates an empty file next to path, to be moved into its
+// place once complete. It gets the permissions of the file at path, or those
+// of a new file if there is none.
+func createStaged(path string) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, fmt.Sprintf(".%s.pz-staging-%d-%d", base, os.Getpid(), i))
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil {
+			if err := f.Chmod(info.Mode().Perm()); err != nil {
+				f.Close()
+				os.Remove(name)
+				return nil, err
+			}
+		}
+		return f, nil
+	}
+}
+
+// backupName returns the first free name of the form
+// dest.pz-backup-20060102-150405, adding -1, -2, ... if needed.
+func backupName(dest string) (string, error) {
+	base := dest + ".pz-backup-" + time.Now().Format("20060102-150405")
+	name := base
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
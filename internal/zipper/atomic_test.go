@@ -0,0 +1,127 @@
+package zipper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeGzipFile writes content to path as a gzip stream naming name.
+func writeGzipFile(t *testing.T, path, name, content string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Name = name
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecompressGzipAtomicKeepsOtherFiles(t *testing.T) {
+	root := t.TempDir()
+	gzPath := filepath.Join(root, "access.log.gz")
+	writeGzipFile(t, gzPath, "access.log", "new log\n")
+
+	destDir := filepath.Join(root, "logs")
+	writeTree(t, destDir, map[string]string{
+		"access.log":    "old log\n",
+		"error.log":     "errors\n",
+		"old/a.log":     "a\n",
+		"old/b.log.txt": "b\n",
+	})
+
+	stats, err := DecompressGzip(gzPath, destDir, ExtractOptions{Atomic: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(destDir, "access.log")); err != nil || string(got) != "new log\n" {
+		t.Errorf("access.log = %q, %v", got, err)
+	}
+
+	// The replaced file is kept next to it, and nothing else changed
+	if filepath.Dir(stats.Backup) != destDir || !strings.HasPrefix(filepath.Base(stats.Backup), "access.log.pz-backup-") {
+		t.Fatalf("backup = %q", stats.Backup)
+	}
+	if got, err := os.ReadFile(stats.Backup); err != nil || string(got) != "old log\n" {
+		t.Errorf("backup = %q, %v", got, err)
+	}
+	want := []string{"access.log", filepath.Base(stats.Backup), "error.log", "old", "old/a.log", "old/b.log.txt"}
+	if got := listTree(t, destDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("destination holds %q, want %q", got, want)
+	}
+	if got := listTree(t, root); len(got) != 2+len(want) {
+		t.Errorf("%s holds %q", root, got)
+	}
+}
+
+func TestDecompressGzipAtomicNewFile(t *testing.T) {
+	root := t.TempDir()
+	gzPath := filepath.Join(root, "data.gz")
+	writeGzipFile(t, gzPath, "data.csv", "1,2,3\n")
+	destDir := filepath.Join(root, "out")
+	writeTree(t, destDir, map[string]string{"other.csv": "x\n"})
+
+	stats, err := DecompressGzip(gzPath, destDir, ExtractOptions{Atomic: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Backup != "" {
+		t.Errorf("backup %q made of a file that did not exist", stats.Backup)
+	}
+	if got := listTree(t, destDir); !reflect.DeepEqual(got, []string{"data.csv", "other.csv"}) {
+		t.Errorf("destination holds %q", got)
+	}
+}
+
+func TestDecompressGzipAtomicFailure(t *testing.T) {
+	root := t.TempDir()
+	gzPath := filepath.Join(root, "access.log.gz")
+	writeGzipFile(t, gzPath, "access.log", strings.Repeat("new log\n", 1000))
+
+	// Break the CRC in the trailer, which is only checked at the end
+	data, err := os.ReadFile(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-8] ^= 0xff
+	if err := os.WriteFile(gzPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	destDir := filepath.Join(root, "logs")
+	writeTree(t, destDir, map[string]string{"access.log": "old log\n", "error.log": "errors\n"})
+
+	if _, err := DecompressGzip(gzPath, destDir, ExtractOptions{Atomic: true}); err == nil {
+		t.Fatal("corrupt stream decompressed without an error")
+	}
+	if got, err := os.ReadFile(filepath.Join(destDir, "access.log")); err != nil || string(got) != "old log\n" {
+		t.Errorf("access.log = %q, %v", got, err)
+	}
+	if got := listTree(t, destDir); !reflect.DeepEqual(got, []string{"access.log", "error.log"}) {
+		t.Errorf("destination holds %q", got)
+	}
+}
+
+func TestAtomicRejectsOtherPolicies(t *testing.T) {
+	root := t.TempDir()
+	gzPath := filepath.Join(root, "a.gz")
+	writeGzipFile(t, gzPath, "a.txt", "a\n")
+
+	opts := ExtractOptions{Atomic: true, Overwrite: OverwriteSkip}
+	if _, err := DecompressGzip(gzPath, filepath.Join(root, "out"), opts); err == nil {
+		t.Error("DecompressGzip accepted Atomic with skip-existing")
+	}
+	if _, err := os.Lstat(filepath.Join(root, "out")); err == nil {
+		t.Error("rejected extraction created the destination")
+	}
+}
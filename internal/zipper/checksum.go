@@ -0,0 +1,158 @@
+package zipper
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// zipChecksumPrefix starts the archive comment that holds a zip's checksum.
+//
+// The checksum is the SHA-256 of the archive as it would be without a
+// comment: every byte up to the end-of-central-directory comment, with the
+// comment length field set to zero. That is exactly what zip.Writer produces
+// before the comment is appended, so the checksum is computed while the
+// archive is written.
+const zipChecksumPrefix = "SHA256: "
+
+// calculateFileChecksum computes SHA-256 checksum of a file
+func calculateFileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// appendZipChecksum finishes a zip archive that was written without a comment
+// by storing sum in its comment. f must be positioned at the end of the archive.
+func appendZipChecksum(f *os.File, sum []byte) (string, error) {
+	checksum := hex.EncodeToString(sum)
+	comment := zipChecksumPrefix + checksum
+
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	// The archive must end with an empty comment, or the checksum would be
+	// appended to whatever comment is already there
+	var length [2]byte
+	if _, err := f.ReadAt(length[:], end-2); err != nil {
+		return "", err
+	}
+	if binary.LittleEndian.Uint16(length[:]) != 0 {
+		return "", fmt.Errorf("archive already has a comment")
+	}
+	binary.LittleEndian.PutUint16(length[:], uint16(len(comment)))
+	if _, err := f.WriteAt(length[:], end-2); err != nil {
+		return "", err
+	}
+	if _, err := f.Write([]byte(comment)); err != nil {
+		return "", err
+	}
+	return checksum, nil
+}
+
+// zipChecksum returns the checksum stored in the comment of the zip archive
+// at path and the checksum of its current contents.
+func zipChecksum(path string) (stored, actual string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", "", err
+	}
+	r, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasPrefix(r.Comment, zipChecksumPrefix) {
+		return "", "", fmt.Errorf("no checksum found in archive")
+	}
+	stored = strings.TrimPrefix(r.Comment, zipChecksumPrefix)
+
+	// The comment length field sits just before the comment, which must end
+	// the file. Anything appended after it changes the archive.
+	lengthAt := info.Size() - int64(len(r.Comment)) - 2
+	tail := make([]byte, len(r.Comment)+2)
+	if _, err := f.ReadAt(tail, lengthAt); err != nil {
+		return "", "", err
+	}
+	if int(binary.LittleEndian.Uint16(tail)) != len(r.Comment) || string(tail[2:]) != r.Comment {
+		return "", "", fmt.Errorf("unexpected data after the end of the archive")
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f, 0, lengthAt)); err != nil {
+		return "", "", err
+	}
+	hash.Write([]byte{0, 0})
+
+	return stored, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeChecksumFile writes checksum to a .sha256 file
+func writeChecksumFile(archivePath, checksum string) error {
+	checksumPath := archivePath + ".sha256"
+	content := fmt.Sprintf("%s *%s\n", checksum, filepath.Base(archivePath))
+	return os.WriteFile(checksumPath, []byte(content), 0644)
+}
+
+// VerifyChecksum verifies the checksum of an archive: the one in the comment
+// of a zip, or the one in the .sha256 file next to any other archive. The
+// format is recognised by content, not by name.
+func VerifyChecksum(archivePath string) (bool, string, error) {
+	format, err := DetectFormat(archivePath)
+	if err != nil {
+		return false, "", err
+	}
+
+	switch format {
+	case FormatZip:
+		// Read checksum from zip comment
+		storedChecksum, actualChecksum, err := zipChecksum(archivePath)
+		if err != nil {
+			return false, "", err
+		}
+
+		return storedChecksum == actualChecksum, storedChecksum, nil
+	case FormatUnknown:
+		return false, "", unsupportedFormat(format)
+	}
+
+	// Read from .sha256 file
+	checksumPath := archivePath + ".sha256"
+	data, err := os.ReadFile(checksumPath)
+	if err != nil {
+		return false, "", fmt.Errorf("checksum file not found: %w", err)
+	}
+
+	parts := strings.Fields(string(data))
+	if len(parts) < 1 {
+		return false, "", fmt.Errorf("invalid checksum file format")
+	}
+
+	storedChecksum := parts[0]
+	actualChecksum, err := calculateFileChecksum(archivePath)
+	if err != nil {
+		return false, "", err
+	}
+
+	return storedChecksum == actualChecksum, storedChecksum, nil
+}
@@ -0,0 +1,179 @@
+package zipper
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeChecksummedZip writes a small zip to path the way ZipFiles does, with
+// comment set on the writer if it is not empty, and returns the checksum.
+func writeChecksummedZip(t *testing.T, path, comment string) (string, error) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	writer := zip.NewWriter(io.MultiWriter(f, hash))
+	for _, name := range []string{"a.txt", "dir/b.txt"} {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, strings.Repeat(name+"\n", 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if comment != "" {
+		if err := writer.SetComment(comment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return appendZipChecksum(f, hash.Sum(nil))
+}
+
+func TestZipChecksum(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.zip")
+	checksum, err := writeChecksummedZip(t, path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, stored, err := VerifyChecksum(path)
+	if err != nil || !ok || stored != checksum {
+		t.Fatalf("VerifyChecksum = %v, %q, %v; want true, %q", ok, stored, err, checksum)
+	}
+
+	// The checksum is that of the archive without its comment
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment := zipChecksumPrefix + checksum
+	bare := append([]byte{}, data[:len(data)-len(comment)]...)
+	bare[len(bare)-2], bare[len(bare)-1] = 0, 0
+	if sum := sha256.Sum256(bare); checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("checksum %s is not the SHA-256 of the archive without its comment", checksum)
+	}
+
+	// The entries can still be read
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.File) != 2 || r.Comment != comment {
+		t.Errorf("archive has %d entries and comment %q", len(r.File), r.Comment)
+	}
+}
+
+func TestZipChecksumDetectsChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(data []byte, checksum string) []byte
+		// wantErr is part of the error expected instead of a mismatch
+		wantErr string
+	}{
+		{
+			name: "tampered entry data",
+			change: func(data []byte, _ string) []byte {
+				// Local header of the first entry is 30 bytes plus its name
+				data[30+len("a.txt")+3] ^= 0xff
+				return data
+			},
+		},
+		{
+			name: "tampered checksum",
+			change: func(data []byte, _ string) []byte {
+				data[len(data)-1] ^= 1
+				return data
+			},
+		},
+		{
+			name: "tampered central directory",
+			change: func(data []byte, checksum string) []byte {
+				// The modification time of the last central directory entry
+				end := len(data) - len(zipChecksumPrefix+checksum) - 22
+				i := strings.LastIndex(string(data[:end]), "PK\x01\x02")
+				data[i+12] ^= 1
+				return data
+			},
+		},
+		{
+			name: "trailing garbage",
+			change: func(data []byte, _ string) []byte {
+				return append(data, "appended"...)
+			},
+			wantErr: "after the end of the archive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.zip")
+			checksum, err := writeChecksummedZip(t, path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.change(data, checksum), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			ok, _, err := VerifyChecksum(path)
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("VerifyChecksum error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("VerifyChecksum: %v", err)
+			case ok:
+				t.Error("VerifyChecksum reported a changed archive as OK")
+			}
+		})
+	}
+}
+
+func TestZipChecksumExistingComment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.zip")
+	if _, err := writeChecksummedZip(t, path, "made elsewhere"); err == nil {
+		t.Fatal("appendZipChecksum accepted an archive that already has a comment")
+	}
+
+	// An archive whose comment is not a checksum has nothing to verify
+	if _, _, err := VerifyChecksum(path); err == nil || !strings.Contains(err.Error(), "no checksum") {
+		t.Errorf("VerifyChecksum error = %v, want no checksum found", err)
+	}
+}
+
+func TestZipChecksumEndToEnd(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"a.txt":     "hello\n",
+		"sub/b.txt": strings.Repeat("data ", 1000),
+	})
+	path := filepath.Join(t.TempDir(), "out.zip")
+	stats, err := ZipWithProgress(src, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, stored, err := VerifyChecksum(path)
+	if err != nil || !ok || stored != stats.Checksum {
+		t.Errorf("VerifyChecksum = %v, %q, %v; want true, %q", ok, stored, err, stats.Checksum)
+	}
+}
@@ -0,0 +1,209 @@
+package zipper
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OverwritePolicy decides what extraction does with files that already exist
+// in the destination.
+type OverwritePolicy int
+
+const (
+	// OverwriteReplace replaces existing files. This is the default.
+	OverwriteReplace OverwritePolicy = iota
+	// OverwriteSkip keeps existing files and skips their entries.
+	OverwriteSkip
+	// OverwriteKeepNewer replaces existing files only if the entry was
+	// modified more recently.
+	OverwriteKeepNewer
+	// OverwriteRename keeps existing files and writes the entry under the
+	// first free name such as "file (1).txt".
+	OverwriteRename
+	// OverwriteFail stops extraction at the first existing file.
+	OverwriteFail
+)
+
+// ParseOverwritePolicy parses "overwrite", "skip-existing", "keep-newer",
+// "rename-new" or "fail".
+func ParseOverwritePolicy(s string) (OverwritePolicy, error) {
+	switch strings.ToLower(s) {
+	case "overwrite", "":
+		return OverwriteReplace, nil
+	case "skip-existing":
+		return OverwriteSkip, nil
+	case "keep-newer":
+		return OverwriteKeepNewer, nil
+	case "rename-new":
+		return OverwriteRename, nil
+	case "fail":
+		return OverwriteFail, nil
+	}
+	return OverwriteReplace, fmt.Errorf("unknown overwrite policy %q (use overwrite, skip-existing, keep-newer, rename-new or fail)", s)
+}
+
+func (p OverwritePolicy) String() string {
+	switch p {
+	case OverwriteSkip:
+		return "skip-existing"
+	case OverwriteKeepNewer:
+		return "keep-newer"
+	case OverwriteRename:
+		return "rename-new"
+	case OverwriteFail:
+		return "fail"
+	default:
+		return "overwrite"
+	}
+}
+
+// ConflictAction is what extraction did with an entry whose destination
+// already existed.
+type ConflictAction int
+
+const (
+	// ConflictOverwritten means the existing file was replaced.
+	ConflictOverwritten ConflictAction = iota
+	// ConflictSkipped means the existing file was kept and the entry skipped.
+	ConflictSkipped
+	// ConflictRenamed means the entry was written under another name.
+	ConflictRenamed
+)
+
+func (a ConflictAction) String() string {
+	switch a {
+	case ConflictSkipped:
+		return "skipped"
+	case ConflictRenamed:
+		return "renamed"
+	default:
+		return "overwritten"
+	}
+}
+
+// Conflict is an entry whose destination already existed before extraction.
+type Conflict struct {
+	Path   string // the existing file
+	Action ConflictAction
+	// NewPath is where the entry was written instead, for ConflictRenamed.
+	NewPath string
+}
+
+// conflictResolver applies an OverwritePolicy to the files an extraction
+// writes, and records the conflicts. Entries are resolved one at a time, in
+// archive order.
+type conflictResolver struct {
+	policy OverwritePolicy
+	// claimed maps the destination of every entry resolved so far to the
+	// path it was given, "" if it was skipped
+	claimed   map[string]string
+	taken     map[string]bool
+	conflicts []Conflict
+}
+
+func newConflictResolver(policy OverwritePolicy) *conflictResolver {
+	return &conflictResolver{
+		policy:  policy,
+		claimed: make(map[string]string),
+		taken:   make(map[string]bool),
+	}
+}
+
+// resolve returns the path to write the entry with modTime to, whose
+// destination is destPath, or "" if the entry is to be skipped. An entry
+// that repeats an earlier one gets the same answer, so the later copy
+// replaces the earlier one as it always has.
+func (c *conflictResolver) resolve(destPath string, modTime time.Time) (string, error) {
+	if p, ok := c.claimed[destPath]; ok {
+		return p, nil
+	}
+	p, err := c.decide(destPath, modTime)
+	if err != nil {
+		return "", err
+	}
+	c.claimed[destPath] = p
+	c.taken[p] = true
+	return p, nil
+}
+
+func (c *conflictResolver) decide(destPath string, modTime time.Time) (string, error) {
+	if c.taken[destPath] {
+		// An earlier entry was renamed to this name and may not be written yet
+		return c.freeName(destPath)
+	}
+	info, err := os.Lstat(destPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return destPath, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	action := ConflictOverwritten
+	switch c.policy {
+	case OverwriteSkip:
+		action = ConflictSkipped
+	case OverwriteKeepNewer:
+		if !info.ModTime().Before(modTime) {
+			action = ConflictSkipped
+		}
+	case OverwriteRename:
+		action = ConflictRenamed
+	case OverwriteFail:
+		return "", fmt.Errorf("%w: %s", fs.ErrExist, destPath)
+	}
+
+	conflict := Conflict{Path: destPath, Action: action}
+	switch action {
+	case ConflictSkipped:
+		destPath = ""
+	case ConflictRenamed:
+		if conflict.NewPath, err = c.freeName(destPath); err != nil {
+			return "", err
+		}
+		destPath = conflict.NewPath
+	}
+	c.conflicts = append(c.conflicts, conflict)
+	return destPath, nil
+}
+
+// freeName returns the first of "name (1).ext", "name (2).ext", ... next to
+// destPath that neither exists nor was given to an earlier entry.
+func (c *conflictResolver) freeName(destPath string) (string, error) {
+	dir := filepath.Dir(destPath)
+	base, ext := SplitArchiveExt(filepath.Base(destPath))
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		if c.taken[candidate] {
+			continue
+		}
+		if _, err := os.Lstat(candidate); errors.Is(err, fs.ErrNotExist) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+// path returns where the entry destined for destPath was written: the new
+// name if it was renamed, destPath otherwise.
+func (c *conflictResolver) path(destPath string) string {
+	if p := c.claimed[destPath]; p != "" {
+		return p
+	}
+	return destPath
+}
+
+// list returns the conflicts sorted by path.
+func (c *conflictResolver) list() []Conflict {
+	sort.Slice(c.conflicts, func(i, j int) bool {
+		return c.conflicts[i].Path < c.conflicts[j].Path
+	})
+	return c.conflicts
+}
@@ -0,0 +1,123 @@
+package zipper
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExtractConflictPolicies(t *testing.T) {
+	// The archive entries carry no modification time, which reads as 1980 in
+	// a zip and 1970 in a tar
+	older := time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Now()
+
+	tests := []struct {
+		name     string
+		policy   string
+		existing time.Time
+		want     map[string]string
+		// wantAction is what was done about a.txt, unless the policy fails
+		wantAction ConflictAction
+		wantErr    bool
+	}{
+		{
+			name:       "overwrite",
+			policy:     "overwrite",
+			existing:   newer,
+			want:       map[string]string{"a.txt": "new", "b.txt": "b"},
+			wantAction: ConflictOverwritten,
+		},
+		{
+			name:       "skip-existing",
+			policy:     "skip-existing",
+			existing:   older,
+			want:       map[string]string{"a.txt": "old", "b.txt": "b"},
+			wantAction: ConflictSkipped,
+		},
+		{
+			name:       "keep-newer with a newer file",
+			policy:     "keep-newer",
+			existing:   newer,
+			want:       map[string]string{"a.txt": "old", "b.txt": "b"},
+			wantAction: ConflictSkipped,
+		},
+		{
+			name:       "keep-newer with an older file",
+			policy:     "keep-newer",
+			existing:   older,
+			want:       map[string]string{"a.txt": "new", "b.txt": "b"},
+			wantAction: ConflictOverwritten,
+		},
+		{
+			name:       "rename-new",
+			policy:     "rename-new",
+			existing:   newer,
+			want:       map[string]string{"a.txt": "old", "a (1).txt": "new", "b.txt": "b"},
+			wantAction: ConflictRenamed,
+		},
+		{
+			name:     "fail",
+			policy:   "fail",
+			existing: older,
+			want:     map[string]string{"a.txt": "old"},
+			wantErr:  true,
+		},
+	}
+	formats := []struct {
+		ext   string
+		write func(t *testing.T, path string, entries []hostileEntry)
+	}{
+		{".zip", writeZipEntries},
+		{".tar.gz", writeTarGzEntries},
+	}
+	for _, tt := range tests {
+		for _, format := range formats {
+			t.Run(tt.name+" "+format.ext, func(t *testing.T) {
+				policy, err := ParseOverwritePolicy(tt.policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				root := t.TempDir()
+				archive := filepath.Join(root, "a"+format.ext)
+				format.write(t, archive, []hostileEntry{
+					{name: "a.txt", body: "new"},
+					{name: "b.txt", body: "b"},
+				})
+				destDir := filepath.Join(root, "out")
+				writeTree(t, destDir, map[string]string{"a.txt": "old"})
+				existing := filepath.Join(destDir, "a.txt")
+				if err := os.Chtimes(existing, tt.existing, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+
+				stats, err := ExtractArchive(archive, destDir, ExtractOptions{Overwrite: policy})
+				if tt.wantErr {
+					if !errors.Is(err, fs.ErrExist) {
+						t.Errorf("error = %v, want one wrapping fs.ErrExist", err)
+					}
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if got := readTreeFiles(t, destDir); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("destination holds %q, want %q", got, tt.want)
+				}
+				if tt.wantErr {
+					return
+				}
+
+				want := []Conflict{{Path: existing, Action: tt.wantAction}}
+				if tt.wantAction == ConflictRenamed {
+					want[0].NewPath = filepath.Join(destDir, "a (1).txt")
+				}
+				if !reflect.DeepEqual(stats.Conflicts, want) {
+					t.Errorf("conflicts = %+v, want %+v", stats.Conflicts, want)
+				}
+			})
+		}
+	}
+}
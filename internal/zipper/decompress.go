@@ -0,0 +1,61 @@
+package zipper
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// decompressor opens the decompressed stream of r.
+type decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// decompressors holds the stream formats a tar archive can be read from.
+var decompressors = map[Format]decompressor{
+	FormatGzip: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	FormatBzip2: func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	},
+	FormatXz: func(r io.Reader) (io.ReadCloser, error) {
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	},
+	FormatZstd: func(r io.Reader) (io.ReadCloser, error) {
+		// A single goroutine keeps memory bounded; the tar reader consumes
+		// the stream sequentially anyway
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	},
+}
+
+// tarExtensions are the conventional names of tar archives in each stream
+// format.
+var tarExtensions = map[Format]string{
+	FormatGzip:  "tar.gz",
+	FormatBzip2: "tar.bz2",
+	FormatXz:    "tar.xz",
+	FormatZstd:  "tar.zst",
+}
+
+// openTarStream returns the tar stream held in r, a stream of format. A plain
+// tar is read as it is.
+func openTarStream(r io.Reader, format Format) (io.ReadCloser, error) {
+	if format == FormatTar {
+		return io.NopCloser(r), nil
+	}
+	decompress, ok := decompressors[format]
+	if !ok {
+		return nil, unsupportedFormat(format)
+	}
+	return decompress(r)
+}
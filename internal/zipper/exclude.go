@@ -0,0 +1,152 @@
+package zipper
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ExcludePolicy decides which files and directories are left out of an
+// archive. The zero value applies the built-in rules only.
+//
+// Patterns use the syntax described on pathPattern: "*.log" matches at any
+// depth, "docs/*.tmp" is anchored to the source root, "**" spans
+// directories and a trailing slash only matches directories. When a
+// directory is excluded nothing below it is visited, so an Include pattern
+// must match the directory itself to keep it.
+//
+// Unless NoIgnoreFiles is set, .gitignore and .pzignore files found while
+// walking are honored with full gitignore semantics, .pzignore rules taking
+// precedence over .gitignore rules in the same directory. When the source
+// directory is a git work tree (it contains .git), .git/info/exclude is read
+// as well and the built-in rules shrink to skipping .git itself, so the
+// archive holds the same files as
+// "git ls-files --cached --others --exclude-standard", except for tracked
+// files that match an ignore pattern and the user's global excludes file.
+type ExcludePolicy struct {
+	// NoDefaults disables the built-in rules: hidden files, dependency,
+	// cache and build directories, and temporary or system files.
+	NoDefaults bool
+
+	// NoIgnoreFiles disables reading .gitignore and .pzignore files.
+	NoIgnoreFiles bool
+
+	// Exclude lists additional patterns to leave out.
+	Exclude []string
+
+	// Include lists patterns that are kept even when a built-in rule or an
+	// Exclude pattern would skip them.
+	Include []string
+}
+
+// excluder is a compiled ExcludePolicy.
+type excluder struct {
+	defaults    bool
+	ignoreFiles bool
+	exclude     []pathPattern
+	include     []pathPattern
+	outputs     map[string]bool
+}
+
+func (p ExcludePolicy) compile() (*excluder, error) {
+	exclude, err := compilePatterns(p.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	include, err := compilePatterns(p.Include)
+	if err != nil {
+		return nil, err
+	}
+	return &excluder{
+		defaults:    !p.NoDefaults,
+		ignoreFiles: !p.NoIgnoreFiles,
+		exclude:     exclude,
+		include:     include,
+	}, nil
+}
+
+// skipOutputs keeps the archive being written, and its checksum file, out of
+// the walk in case the archive is created inside one of the sources.
+func (e *excluder) skipOutputs(archivePath string) {
+	abs, err := filepath.Abs(archivePath)
+	if err != nil {
+		return
+	}
+	e.outputs = map[string]bool{abs: true, abs + ".sha256": true}
+}
+
+// isOutput reports whether path is one of the files registered with skipOutputs.
+func (e *excluder) isOutput(path string) bool {
+	if e == nil || len(e.outputs) == 0 {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	return err == nil && e.outputs[abs]
+}
+
+// walkFilter applies an excluder to one source tree. Ignore files are loaded
+// as the walk enters each directory.
+type walkFilter struct {
+	ex      *excluder
+	ignore  *ignoreMatcher
+	gitRepo bool
+}
+
+// forTree prepares a filter for walking the tree rooted at root.
+func (e *excluder) forTree(root string) (*walkFilter, error) {
+	f := &walkFilter{ex: e}
+	if e == nil {
+		return f, nil
+	}
+	if _, err := os.Lstat(filepath.Join(root, ".git")); err == nil {
+		f.gitRepo = true
+	}
+	if e.ignoreFiles {
+		f.ignore = newIgnoreMatcher()
+		if f.gitRepo {
+			rules, err := readIgnoreFile(filepath.Join(root, ".git", "info", "exclude"))
+			if err != nil {
+				return nil, err
+			}
+			f.ignore.rules[""] = rules
+		}
+	}
+	return f, nil
+}
+
+// enterDir loads the ignore files of the directory at dirPath. The root has
+// rel ".".
+func (f *walkFilter) enterDir(dirPath, rel string) error {
+	if f.ignore == nil {
+		return nil
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return f.ignore.load(dirPath, filepath.ToSlash(rel))
+}
+
+// skip reports whether the entry at the slash-separated path rel is excluded.
+func (f *walkFilter) skip(rel string, isDir bool) bool {
+	e := f.ex
+	if e == nil {
+		return false
+	}
+
+	excluded := false
+	switch {
+	case e.defaults && f.gitRepo && f.ignore != nil:
+		// The repository's own ignore files say what is junk
+		excluded = rel == ".git"
+	case e.defaults:
+		excluded = shouldSkip(path.Base(rel), isDir)
+	}
+	excluded = excluded || matchAny(e.exclude, rel, isDir)
+	if !excluded && f.ignore != nil {
+		excluded = f.ignore.ignored(rel, isDir)
+	}
+	if !excluded {
+		return false
+	}
+	return !matchAny(e.include, rel, isDir)
+}
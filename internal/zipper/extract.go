@@ -0,0 +1,598 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// ExtractStats describes the data extracted from an archive.
+type ExtractStats struct {
+	TotalBytes int64
+	FileCount  int
+	// Conflicts lists the entries whose destination already existed, sorted
+	// by path, and what was done about each.
+	Conflicts []Conflict
+	// Backup is where an atomic extraction moved the previous contents of
+	// the destination, or "" if it did not exist.
+	Backup string
+}
+
+// ExtractArchive extracts a zip archive or a tar, plain or compressed with
+// gzip, bzip2, xz or zstd, recognised by its content rather than its name, as
+// configured by opts. A gzip stream that holds a single file rather than a
+// tar is decompressed with DecompressGzip.
+func ExtractArchive(archivePath, destDir string, opts ExtractOptions) (stats ExtractStats, err error) {
+	info, err := readableArchive(archivePath)
+	if err != nil {
+		return stats, err
+	}
+	switch {
+	case info.Format == FormatZip:
+		return ExtractWithOptions(archivePath, destDir, opts)
+	case info.Format == FormatGzip && !info.Tar:
+		return DecompressGzip(archivePath, destDir, opts)
+	}
+	return extractTar(archivePath, destDir, info.Format, opts)
+}
+
+// Extract extracts a zip archive to the destination directory.
+func Extract(zipPath, destDir string) error {
+	_, err := ExtractWithProgress(zipPath, destDir, nil)
+	return err
+}
+
+// ExtractWithProgress extracts a zip archive and reports progress via callback.
+func ExtractWithProgress(zipPath, destDir string, progress ProgressFunc) (stats ExtractStats, err error) {
+	return ExtractWithOptions(zipPath, destDir, ExtractOptions{Progress: progress})
+}
+
+// ExtractWithOptions extracts a zip archive as configured by opts. If it
+// fails, whatever it created below destDir is removed again.
+func ExtractWithOptions(zipPath, destDir string, opts ExtractOptions) (stats ExtractStats, err error) {
+	if opts.Atomic {
+		return extractAtomic(destDir, opts, func(destDir string, opts ExtractOptions) (ExtractStats, error) {
+			return ExtractWithOptions(zipPath, destDir, opts)
+		})
+	}
+
+	progress := opts.Progress
+	restore := newMetadataRestorer(opts)
+	limiter := newExtractLimiter(opts.Limits)
+	conflicts := newConflictResolver(opts.Overwrite)
+	out := &outputTracker{}
+	defer func() {
+		if err != nil {
+			out.cleanup()
+		}
+	}()
+
+	sel, err := newEntrySelector(opts)
+	if err != nil {
+		return stats, err
+	}
+
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return stats, err
+	}
+	defer reader.Close()
+
+	// The central directory lists every entry, so unselected ones are
+	// never read at all
+	type zipEntry struct {
+		file *zip.File
+		name string // name to extract to
+	}
+	entries := make([]zipEntry, 0, len(reader.File))
+	for _, f := range reader.File {
+		if !sel.match(f.Name, f.FileInfo().IsDir()) {
+			continue
+		}
+		if name, ok := opts.entryName(f.Name); ok {
+			entries = append(entries, zipEntry{file: f, name: name})
+		}
+	}
+
+	// Calculate total size and check the limits against what the archive declares
+	totalBytes := int64(0)
+	fileCount := 0
+	for _, e := range entries {
+		if err := limiter.entry(e.name); err != nil {
+			return stats, err
+		}
+		if !e.file.FileInfo().IsDir() && !isZipSymlink(e.file) {
+			if err := limiter.declare(e.name, int64(e.file.UncompressedSize64)); err != nil {
+				return stats, err
+			}
+			totalBytes += int64(e.file.UncompressedSize64)
+			fileCount++
+		}
+	}
+
+	stats.TotalBytes = totalBytes
+	stats.FileCount = fileCount
+
+	if opts.DryRun {
+		for _, e := range entries {
+			if e.file.FileInfo().IsDir() {
+				continue
+			}
+			if !filepath.IsLocal(e.name) {
+				return stats, fmt.Errorf("invalid file path: %s", e.name)
+			}
+			if _, err := conflicts.resolve(filepath.Join(destDir, filepath.FromSlash(e.name)), zipModTime(e.file)); err != nil {
+				return stats, err
+			}
+		}
+		stats.Conflicts = conflicts.list()
+		return stats, nil
+	}
+
+	done := int64(0)
+	var doneMutex sync.Mutex
+	callProgress := func() {
+		if progress != nil {
+			doneMutex.Lock()
+			progress(done, totalBytes)
+			doneMutex.Unlock()
+		}
+	}
+	callProgress()
+
+	if err := out.mkdirAll(destDir); err != nil {
+		return stats, err
+	}
+
+	// Create directories first, and read symlinks to create them last
+	var links []symlinkEntry
+	for _, e := range entries {
+		if isZipSymlink(e.file) {
+			link, err := readZipSymlink(e.file)
+			if err != nil {
+				return stats, err
+			}
+			link.name = e.name
+			link.meta.modTime = zipModTime(e.file)
+			links = append(links, link)
+			continue
+		}
+		if e.file.FileInfo().IsDir() {
+			destPath := filepath.Join(destDir, filepath.FromSlash(e.name))
+			if !filepath.IsLocal(e.name) {
+				return stats, fmt.Errorf("invalid file path: %s", e.name)
+			}
+			if err := out.mkdirAll(destPath); err != nil {
+				return stats, err
+			}
+			restore.dir(entryMeta{path: destPath, mode: e.file.Mode(), modTime: zipModTime(e.file)})
+		}
+	}
+
+	// Extract files in parallel
+	workerCount := getWorkerCount()
+	type extractJob struct {
+		file     *zip.File
+		name     string
+		destPath string
+	}
+
+	jobChan := make(chan extractJob, len(entries))
+	errChan := make(chan error, 1)
+	var wg sync.WaitGroup
+
+	// Start workers
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobChan {
+				rc, err := job.file.Open()
+				if err != nil {
+					select {
+					case errChan <- err:
+					default:
+					}
+					return
+				}
+
+				out.create(job.destPath)
+				outFile, err := os.OpenFile(job.destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, job.file.Mode())
+				if err != nil {
+					rc.Close()
+					select {
+					case errChan <- err:
+					default:
+					}
+					return
+				}
+
+				// The reader stops at the compressed size, so the ratio
+				// bounds what a single entry can expand to
+				w := limiter.writer(outFile, job.name, int64(job.file.CompressedSize64))
+				written, err := io.Copy(w, rc)
+				rc.Close()
+				outFile.Close()
+
+				if err == nil {
+					err = restore.file(entryMeta{path: job.destPath, mode: job.file.Mode(), modTime: zipModTime(job.file)})
+				}
+				if err != nil {
+					select {
+					case errChan <- err:
+					default:
+					}
+					return
+				}
+
+				doneMutex.Lock()
+				done += written
+				doneMutex.Unlock()
+
+				if progress != nil {
+					callProgress()
+				}
+			}
+		}()
+	}
+
+	// Send jobs
+	go func() {
+		for _, e := range entries {
+			if e.file.FileInfo().IsDir() || isZipSymlink(e.file) {
+				continue
+			}
+
+			destPath := filepath.Join(destDir, filepath.FromSlash(e.name))
+
+			// Security check: prevent path traversal
+			if !filepath.IsLocal(e.name) {
+				select {
+				case errChan <- fmt.Errorf("invalid file path: %s", e.name):
+				default:
+				}
+				break
+			}
+
+			destPath, err := conflicts.resolve(destPath, zipModTime(e.file))
+			if err != nil {
+				select {
+				case errChan <- err:
+				default:
+				}
+				break
+			}
+			if destPath == "" {
+				// The existing file is kept
+				doneMutex.Lock()
+				done += int64(e.file.UncompressedSize64)
+				doneMutex.Unlock()
+				continue
+			}
+
+			// Ensure parent directory exists
+			if err := out.mkdirAll(filepath.Dir(destPath)); err != nil {
+				select {
+				case errChan <- err:
+				default:
+				}
+				break
+			}
+
+			jobChan <- extractJob{file: e.file, name: e.name, destPath: destPath}
+		}
+		close(jobChan)
+	}()
+
+	// Wait for completion
+	wg.Wait()
+	close(errChan)
+
+	// Check for errors
+	if err := <-errChan; err != nil {
+		return stats, err
+	}
+
+	if err := createSymlinks(destDir, links, restore, out, conflicts); err != nil {
+		return stats, err
+	}
+	if err := restore.finish(); err != nil {
+		return stats, err
+	}
+
+	stats.Conflicts = conflicts.list()
+	callProgress()
+	return stats, nil
+}
+
+type progressReader struct {
+	r        io.Reader
+	done     *int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if pr.progress != nil && n > 0 {
+		*pr.done += int64(n)
+		pr.progress(*pr.done, pr.total)
+	}
+	return n, err
+}
+
+// ExtractGzip extracts a tar.gz archive to the destination directory
+func ExtractGzip(gzipPath, destDir string) error {
+	_, err := ExtractGzipWithProgress(gzipPath, destDir, nil)
+	return err
+}
+
+// ExtractGzipWithProgress extracts a tar.gz archive and reports progress via callback
+func ExtractGzipWithProgress(gzipPath, destDir string, progress ProgressFunc) (stats ExtractStats, err error) {
+	return ExtractGzipWithOptions(gzipPath, destDir, ExtractOptions{Progress: progress})
+}
+
+// ExtractGzipWithOptions extracts a tar.gz archive as configured by opts. If it
+// fails, whatever it created below destDir is removed again.
+func ExtractGzipWithOptions(gzipPath, destDir string, opts ExtractOptions) (stats ExtractStats, err error) {
+	return extractTar(gzipPath, destDir, FormatGzip, opts)
+}
+
+// extractTar extracts a tar archive compressed as a stream of format. If it
+// fails, whatever it created below destDir is removed again.
+//
+// Extracting everything takes two passes, the first to check the limits and
+// total up the size for progress. When only some entries are selected, or
+// for a dry run, the archive is read once and the others are skipped as the
+// stream goes by; progress then follows the compressed bytes read.
+func extractTar(archivePath, destDir string, format Format, opts ExtractOptions) (stats ExtractStats, err error) {
+	if opts.Atomic {
+		return extractAtomic(destDir, opts, func(destDir string, opts ExtractOptions) (ExtractStats, error) {
+			return extractTar(archivePath, destDir, format, opts)
+		})
+	}
+
+	progress := opts.Progress
+	restore := newMetadataRestorer(opts)
+	limiter := newExtractLimiter(opts.Limits)
+	conflicts := newConflictResolver(opts.Overwrite)
+	out := &outputTracker{}
+	defer func() {
+		if err != nil {
+			out.cleanup()
+		}
+	}()
+
+	sel, err := newEntrySelector(opts)
+	if err != nil {
+		return stats, err
+	}
+
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		return stats, err
+	}
+	defer archiveFile.Close()
+
+	done := int64(0)
+	totalBytes := int64(0)
+	fileProgress := progress
+	var archiveReader io.Reader = archiveFile
+	twoPass := sel.all() && !opts.DryRun
+	if twoPass {
+		// First pass: calculate total size and check the limits. Skipping
+		// over the entries decompresses them, so the ratio is checked here
+		// already.
+		compressed := &countingReader{r: archiveFile}
+		stream, err := openTarStream(compressed, format)
+		if err != nil {
+			return stats, err
+		}
+		defer stream.Close()
+
+		tarReader := tar.NewReader(limiter.stream(stream, compressed))
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return stats, err
+			}
+			name, ok := opts.entryName(header.Name)
+			if !ok {
+				continue
+			}
+			if err := limiter.entry(name); err != nil {
+				return stats, err
+			}
+			if header.Typeflag == tar.TypeReg {
+				if err := limiter.declare(name, header.Size); err != nil {
+					return stats, err
+				}
+				totalBytes += header.Size
+				stats.FileCount++
+			}
+		}
+		stats.TotalBytes = totalBytes
+
+		// Reopen for actual extraction
+		if _, err := archiveFile.Seek(0, io.SeekStart); err != nil {
+			return stats, err
+		}
+	} else {
+		info, err := archiveFile.Stat()
+		if err != nil {
+			return stats, err
+		}
+		totalBytes = info.Size()
+		archiveReader = &progressReader{r: archiveFile, done: &done, total: totalBytes, progress: progress}
+		fileProgress = nil
+	}
+
+	if progress != nil {
+		progress(0, totalBytes)
+	}
+
+	compressed := &countingReader{r: archiveReader}
+	stream, err := openTarStream(compressed, format)
+	if err != nil {
+		return stats, err
+	}
+	defer stream.Close()
+
+	tarStream := limiter.stream(stream, compressed)
+	tarReader := tar.NewReader(tarStream)
+
+	if !opts.DryRun {
+		if err := out.mkdirAll(destDir); err != nil {
+			return stats, err
+		}
+	}
+
+	// With a selection, hard links can only be created if their target was
+	// extracted
+	extracted := make(map[string]bool)
+	var links []symlinkEntry
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		if !sel.match(header.Name, header.Typeflag == tar.TypeDir) {
+			continue
+		}
+		name, ok := opts.entryName(header.Name)
+		if !ok {
+			continue
+		}
+		if !twoPass {
+			// Checked by the first pass otherwise
+			if err := limiter.entry(name); err != nil {
+				return stats, err
+			}
+			if header.Typeflag == tar.TypeReg {
+				if err := limiter.declare(name, header.Size); err != nil {
+					return stats, err
+				}
+				stats.TotalBytes += header.Size
+				stats.FileCount++
+			}
+		}
+
+		destPath := filepath.Join(destDir, filepath.FromSlash(name))
+
+		// Security check: prevent path traversal
+		if !filepath.IsLocal(name) {
+			return stats, fmt.Errorf("invalid file path: %s", name)
+		}
+		if opts.DryRun {
+			switch header.Typeflag {
+			case tar.TypeReg, tar.TypeLink, tar.TypeSymlink:
+				if _, err := conflicts.resolve(destPath, header.ModTime); err != nil {
+					return stats, err
+				}
+			}
+			continue
+		}
+
+		meta := entryMeta{
+			path:     destPath,
+			mode:     header.FileInfo().Mode(),
+			modTime:  header.ModTime,
+			uid:      header.Uid,
+			gid:      header.Gid,
+			hasOwner: true,
+			xattrs:   xattrRecords(header),
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := out.mkdirAll(destPath); err != nil {
+				return stats, err
+			}
+			restore.dir(meta)
+		case tar.TypeReg:
+			if !sel.all() {
+				extracted[path.Clean(header.Name)] = true
+			}
+			resolved, err := conflicts.resolve(destPath, header.ModTime)
+			if err != nil {
+				return stats, err
+			}
+			if resolved == "" {
+				// The existing file is kept
+				continue
+			}
+			destPath, meta.path = resolved, resolved
+
+			// Ensure parent directory exists
+			if err := out.mkdirAll(filepath.Dir(destPath)); err != nil {
+				return stats, err
+			}
+
+			out.create(destPath)
+			outFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode))
+			if err != nil {
+				return stats, err
+			}
+
+			pr := &progressReader{
+				r:        tarReader,
+				done:     &done,
+				total:    totalBytes,
+				progress: fileProgress,
+			}
+
+			// The ratio is checked on the stream as a whole
+			if _, err = io.Copy(limiter.writer(outFile, name, -1), pr); err != nil {
+				outFile.Close()
+				return stats, err
+			}
+			if err := outFile.Close(); err != nil {
+				return stats, err
+			}
+			if err := restore.file(meta); err != nil {
+				return stats, err
+			}
+		case tar.TypeLink:
+			// The target is an entry of the archive, so it is renamed too
+			target, ok := opts.entryName(header.Linkname)
+			if !ok || !sel.all() && !extracted[path.Clean(header.Linkname)] {
+				return stats, fmt.Errorf("hard link %s -> %s: the target is not selected for extraction", header.Name, header.Linkname)
+			}
+			if err := createHardLink(destDir, name, target, header.ModTime, out, conflicts); err != nil {
+				return stats, err
+			}
+		case tar.TypeSymlink:
+			links = append(links, symlinkEntry{name: name, target: header.Linkname, meta: meta})
+		}
+	}
+
+	// The tar reader stops at the end-of-archive marker; read on so the
+	// checksum of the compressed stream is verified
+	if _, err := io.Copy(io.Discard, tarStream); err != nil {
+		return stats, err
+	}
+
+	if err := createSymlinks(destDir, links, restore, out, conflicts); err != nil {
+		return stats, err
+	}
+	if err := restore.finish(); err != nil {
+		return stats, err
+	}
+
+	stats.Conflicts = conflicts.list()
+	if progress != nil {
+		progress(totalBytes, totalBytes)
+	}
+	return stats, nil
+}
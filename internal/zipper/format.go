@@ -0,0 +1,191 @@
+package zipper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Format is an archive or compression format recognised by its magic bytes.
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	FormatZip
+	FormatTar
+	FormatGzip
+	FormatBzip2
+	FormatXz
+	FormatZstd
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatZip:
+		return "zip"
+	case FormatTar:
+		return "tar"
+	case FormatGzip:
+		return "gzip"
+	case FormatBzip2:
+		return "bzip2"
+	case FormatXz:
+		return "xz"
+	case FormatZstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+// tarMagicOffset is where the "ustar" magic sits in a tar header block.
+const tarMagicOffset = 257
+
+// tarBlockSize is the size of a tar header block, and so the most that has to
+// be read to recognise any supported format.
+const tarBlockSize = 512
+
+var formatMagic = []struct {
+	format Format
+	magic  []byte
+}{
+	{FormatZip, []byte("PK\x03\x04")},
+	{FormatZip, []byte("PK\x05\x06")}, // empty archive: only the end of central directory
+	{FormatZip, []byte("PK\x07\x08")}, // spanned archive marker
+	{FormatGzip, []byte{0x1f, 0x8b}},
+	{FormatBzip2, []byte("BZh")},
+	{FormatXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{FormatZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// sniffFormat recognises the format of data, the first bytes of a file.
+// POSIX and GNU tar both start their magic with "ustar".
+func sniffFormat(data []byte) Format {
+	for _, m := range formatMagic {
+		if bytes.HasPrefix(data, m.magic) {
+			return m.format
+		}
+	}
+	if len(data) >= tarMagicOffset+5 && string(data[tarMagicOffset:tarMagicOffset+5]) == "ustar" {
+		return FormatTar
+	}
+	return FormatUnknown
+}
+
+// readHead reads up to one tar block from the start of r.
+func readHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, tarBlockSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// DetectFormat reads the first bytes of the file at path and reports its
+// format, whatever its name.
+func DetectFormat(path string) (Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FormatUnknown, err
+	}
+	defer f.Close()
+
+	head, err := readHead(f)
+	if err != nil {
+		return FormatUnknown, err
+	}
+	return sniffFormat(head), nil
+}
+
+// ArchiveInfo describes what DetectArchive found.
+type ArchiveInfo struct {
+	// Format is the outermost format of the file.
+	Format Format
+	// Tar reports whether a compressed stream holds a tar archive.
+	Tar bool
+	// Size is the size of the file in bytes.
+	Size int64
+}
+
+// Name returns the conventional name of the format, such as "tar.gz".
+func (i ArchiveInfo) Name() string {
+	if ext, ok := tarExtensions[i.Format]; ok && i.Tar {
+		return ext
+	}
+	return i.Format.String()
+}
+
+// DetectArchive reports the format of the file at path and, for a compressed
+// stream, whether it holds a tar archive.
+func DetectArchive(path string) (info ArchiveInfo, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return info, err
+	}
+	info.Size = stat.Size()
+
+	head, err := readHead(f)
+	if err != nil {
+		return info, err
+	}
+	info.Format = sniffFormat(head)
+
+	if decompress, ok := decompressors[info.Format]; ok {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return info, err
+		}
+		stream, err := decompress(f)
+		if err != nil {
+			return info, err
+		}
+		defer stream.Close()
+
+		inner, err := readHead(stream)
+		if err != nil {
+			return info, err
+		}
+		info.Tar = sniffFormat(inner) == FormatTar
+	}
+	return info, nil
+}
+
+// readableArchive detects the format of the archive at path and checks that
+// it is one that can be listed, tested and extracted: zip, a tar, plain or in
+// one of the compressed stream formats, or a gzip stream of a single file.
+func readableArchive(path string) (ArchiveInfo, error) {
+	info, err := DetectArchive(path)
+	if err != nil {
+		return info, err
+	}
+	_, compressed := decompressors[info.Format]
+	switch {
+	case info.Format == FormatZip, info.Format == FormatTar, info.Format == FormatGzip:
+		return info, nil
+	case compressed && info.Tar:
+		return info, nil
+	case compressed:
+		return info, fmt.Errorf("%w: %s stream without a tar archive", errUnsupportedFormat, info.Format)
+	}
+	return info, unsupportedFormat(info.Format)
+}
+
+// errUnsupportedFormat is returned for archives that are recognised but
+// cannot be read.
+var errUnsupportedFormat = errors.New("unsupported archive format")
+
+// unsupportedFormat reports that archives of format f cannot be handled.
+func unsupportedFormat(f Format) error {
+	if f == FormatUnknown {
+		return fmt.Errorf("%w: content not recognised", errUnsupportedFormat)
+	}
+	return fmt.Errorf("%w: %s", errUnsupportedFormat, f)
+}
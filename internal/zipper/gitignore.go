@@ -0,0 +1,131 @@
+package zipper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the per-directory ignore files read while walking a source tree.
+const (
+	gitIgnoreFile = ".gitignore"
+	pzIgnoreFile  = ".pzignore"
+)
+
+// ignoreRule is one line of an ignore file.
+type ignoreRule struct {
+	pattern pathPattern
+	negate  bool
+}
+
+// ignoreMatcher holds the ignore rules loaded so far, keyed by the
+// slash-separated directory they were found in ("" for the root).
+type ignoreMatcher struct {
+	rules map[string][]ignoreRule
+}
+
+func newIgnoreMatcher() *ignoreMatcher {
+	return &ignoreMatcher{rules: make(map[string][]ignoreRule)}
+}
+
+// load reads the ignore files of the directory at dirPath. Rules from
+// .pzignore come after those from .gitignore so archive-specific rules win.
+func (m *ignoreMatcher) load(dirPath, rel string) error {
+	for _, name := range []string{gitIgnoreFile, pzIgnoreFile} {
+		rules, err := readIgnoreFile(filepath.Join(dirPath, name))
+		if err != nil {
+			return err
+		}
+		m.rules[rel] = append(m.rules[rel], rules...)
+	}
+	return nil
+}
+
+// ignored reports whether rel is ignored. As in git, rules in deeper
+// directories take precedence over shallower ones, later rules in a file
+// take precedence over earlier ones, and a negated rule re-includes a path.
+func (m *ignoreMatcher) ignored(rel string, isDir bool) bool {
+	ignored := false
+	dirs := strings.Split(rel, "/")
+	base := ""
+	for i := 0; i < len(dirs); i++ {
+		if i > 0 {
+			base = path.Join(base, dirs[i-1])
+		}
+		rules := m.rules[base]
+		if len(rules) == 0 {
+			continue
+		}
+		sub := strings.Join(dirs[i:], "/")
+		for _, rule := range rules {
+			if rule.pattern.match(sub, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// readIgnoreFile parses a file in .gitignore syntax. A missing file has no rules.
+func readIgnoreFile(name string) ([]ignoreRule, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		rule, ok, err := parseIgnoreLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, lineNo, err)
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// parseIgnoreLine parses one line of an ignore file, reporting false for
+// blank lines and comments.
+func parseIgnoreLine(line string) (ignoreRule, bool, error) {
+	var rule ignoreRule
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are ignored unless escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false, nil
+	}
+
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if line == "" || line == "/" {
+		return rule, false, nil
+	}
+
+	pattern, err := compilePattern(line)
+	if err != nil {
+		return rule, false, err
+	}
+	rule.pattern = pattern
+	return rule, true, nil
+}
@@ -0,0 +1,203 @@
+package zipper
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeTree creates the files of tree below dir, keyed by slash-separated path.
+func writeTree(t *testing.T, dir string, tree map[string]string) {
+	t.Helper()
+	for name, content := range tree {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// keptFiles walks dir with the ignore files honored and returns the regular
+// files kept, leaving out the ignore files themselves.
+func keptFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	ex, err := ExcludePolicy{NoDefaults: true}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := []string{}
+	err = walkFiles(dir, "", ex, SymlinksStore, func(job fileJob) error {
+		name := filepath.ToSlash(job.rel)
+		if !job.isDir && path.Base(name) != gitIgnoreFile && path.Base(name) != pzIgnoreFile {
+			kept = append(kept, name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(kept)
+	return kept
+}
+
+// The expected results are what "git ls-files --others --exclude-standard"
+// lists for the same trees.
+func TestIgnoreFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		tree map[string]string
+		want []string
+	}{
+		{
+			name: "negation",
+			tree: map[string]string{
+				".gitignore":   "*.log\n!keep.log\n",
+				"a.log":        "",
+				"keep.log":     "",
+				"sub/b.log":    "",
+				"sub/keep.log": "",
+				"main.go":      "",
+			},
+			want: []string{"keep.log", "main.go", "sub/keep.log"},
+		},
+		{
+			name: "negation inside an ignored directory",
+			tree: map[string]string{
+				".gitignore":     "build/\n!build/keep.txt\n",
+				"build/keep.txt": "",
+				"build/out.bin":  "",
+				"src/a.go":       "",
+			},
+			want: []string{"src/a.go"},
+		},
+		{
+			name: "re-including a directory's contents",
+			tree: map[string]string{
+				".gitignore":     "build/*\n!build/keep.txt\n",
+				"build/keep.txt": "",
+				"build/out.bin":  "",
+			},
+			want: []string{"build/keep.txt"},
+		},
+		{
+			name: "anchoring",
+			tree: map[string]string{
+				".gitignore":       "/build\ndocs/*.tmp\n",
+				"build/x":          "",
+				"src/build/x":      "",
+				"docs/a.tmp":       "",
+				"docs/sub/b.tmp":   "",
+				"other/docs/c.tmp": "",
+			},
+			want: []string{"docs/sub/b.tmp", "other/docs/c.tmp", "src/build/x"},
+		},
+		{
+			name: "directory only",
+			tree: map[string]string{
+				".gitignore":  "logs/\n",
+				"logs/a.txt":  "",
+				"app/logs/b":  "",
+				"other/logs":  "",
+				"other/x.txt": "",
+			},
+			want: []string{"other/logs", "other/x.txt"},
+		},
+		{
+			name: "double star",
+			tree: map[string]string{
+				".gitignore":     "**/tmp\nout/**\ndocs/**/*.bak\n",
+				"tmp":            "",
+				"a/b/tmp":        "",
+				"out/x/y":        "",
+				"docs/a.bak":     "",
+				"docs/x/y/b.bak": "",
+				"docs/x/y/b.txt": "",
+				"src/docs/c.bak": "",
+			},
+			want: []string{"docs/x/y/b.txt", "src/docs/c.bak"},
+		},
+		{
+			name: "nested ignore files",
+			tree: map[string]string{
+				".gitignore":       "*.txt\n",
+				"keep.txt":         "",
+				"sub/.gitignore":   "!keep.txt\n/gen\n",
+				"sub/keep.txt":     "",
+				"sub/other.txt":    "",
+				"sub/gen/a.go":     "",
+				"sub/x/gen/b.go":   "",
+				"sub/x/keep.txt":   "",
+				"sub/x/other.txt":  "",
+				"sub/y/.gitignore": "keep.txt\n",
+				"sub/y/keep.txt":   "",
+			},
+			want: []string{"sub/keep.txt", "sub/x/gen/b.go", "sub/x/keep.txt"},
+		},
+		{
+			name: "character classes",
+			tree: map[string]string{
+				".gitignore": "[[:digit:]]*.tmp\n[!a]x\n",
+				"1.tmp":      "",
+				"a1.tmp":     "",
+				"ax":         "",
+				"bx":         "",
+			},
+			want: []string{"a1.tmp", "ax"},
+		},
+		{
+			name: "non-ASCII names",
+			tree: map[string]string{
+				".gitignore":    "日志/\ncafé*.txt\n",
+				"日志/a.log":      "",
+				"café noir.txt": "",
+				"cafe.txt":      "",
+			},
+			want: []string{"cafe.txt"},
+		},
+		{
+			name: "escapes, comments and trailing spaces",
+			tree: map[string]string{
+				".gitignore": "# comment\n\\#hash\n\\!bang\nspace\\ \ntrail   \n",
+				"# comment":  "",
+				"#hash":      "",
+				"!bang":      "",
+				"space ":     "",
+				"trail":      "",
+				"kept":       "",
+			},
+			want: []string{"# comment", "kept"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, tt.tree)
+			if got := keptFiles(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPzIgnoreOverridesGitIgnore(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".gitignore":     "*.bin\n",
+		".pzignore":      "!model.bin\n*.md\n",
+		"model.bin":      "",
+		"other.bin":      "",
+		"README.md":      "",
+		"sub/.gitignore": "model.bin\n",
+		"sub/model.bin":  "",
+	})
+	want := []string{"model.bin"}
+	if got := keptFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("kept %q, want %q", got, want)
+	}
+}
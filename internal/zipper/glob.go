@@ -0,0 +1,213 @@
+package zipper
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// pathPattern is a compiled glob for slash-separated relative paths.
+//
+// '*' and '?' never match '/', '**' matches any number of path elements and
+// '[...]' is a character class ('!' or '^' negates it, and it may hold POSIX
+// classes such as [:alpha:]). A pattern without a slash matches the last
+// path element at any depth; a pattern containing a slash is anchored to the
+// root. A trailing slash restricts the pattern to directories.
+type pathPattern struct {
+	re       *regexp.Regexp
+	baseOnly bool
+	dirOnly  bool
+}
+
+func compilePattern(pattern string) (pathPattern, error) {
+	var p pathPattern
+	glob := pattern
+	if strings.HasSuffix(glob, "/") {
+		p.dirOnly = true
+		glob = strings.TrimRight(glob, "/")
+	}
+	if !strings.Contains(glob, "/") {
+		p.baseOnly = true
+	}
+	glob = strings.TrimPrefix(glob, "/")
+	if glob == "" {
+		return p, fmt.Errorf("invalid pattern %q", pattern)
+	}
+
+	expr, err := globToRegexp(glob)
+	if err != nil {
+		return p, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	p.re, err = regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return p, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return p, nil
+}
+
+func compilePatterns(patterns []string) ([]pathPattern, error) {
+	compiled := make([]pathPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := compilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+// match reports whether the slash-separated relative path rel matches.
+func (p pathPattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.baseOnly {
+		return p.re.MatchString(path.Base(rel))
+	}
+	return p.re.MatchString(rel)
+}
+
+func matchAny(patterns []pathPattern, rel string, isDir bool) bool {
+	for _, p := range patterns {
+		if p.match(rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWithin reports whether rel, or one of the directories it is in,
+// matches one of patterns.
+func matchWithin(patterns []pathPattern, rel string, isDir bool) bool {
+	for {
+		if matchAny(patterns, rel, isDir) {
+			return true
+		}
+		parent := strings.LastIndexByte(rel, '/')
+		if parent <= 0 {
+			return false
+		}
+		rel, isDir = rel[:parent], true
+	}
+}
+
+// globToRegexp translates a glob into an unanchored regular expression.
+func globToRegexp(glob string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				i++
+				switch {
+				case atStart && i+1 < len(glob) && glob[i+1] == '/':
+					// "**/" matches zero or more leading directories
+					b.WriteString("(?:.*/)?")
+					i++
+				case atStart && i+1 == len(glob):
+					// trailing "**" matches everything inside
+					b.WriteString(".*")
+				default:
+					b.WriteString("[^/]*")
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			class, n, err := bracketToRegexp(glob[i:])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(class)
+			i += n - 1
+		case '\\':
+			if i+1 == len(glob) {
+				b.WriteString(regexp.QuoteMeta(`\`))
+				continue
+			}
+			_, n := utf8.DecodeRuneInString(glob[i+1:])
+			b.WriteString(regexp.QuoteMeta(glob[i+1 : i+1+n]))
+			i += n
+		default:
+			// Quote the whole run of literal text, so characters of more
+			// than one byte stay intact
+			j := i + 1
+			for j < len(glob) && strings.IndexByte(`*?[\`, glob[j]) < 0 {
+				j++
+			}
+			b.WriteString(regexp.QuoteMeta(glob[i:j]))
+			i = j - 1
+		}
+	}
+	return b.String(), nil
+}
+
+// posixClasses are the character classes allowed as [:name:] inside a
+// bracket expression.
+var posixClasses = map[string]bool{
+	"alnum": true, "alpha": true, "blank": true, "cntrl": true,
+	"digit": true, "graph": true, "lower": true, "print": true,
+	"punct": true, "space": true, "upper": true, "xdigit": true,
+}
+
+// bracketToRegexp translates the bracket expression at the start of glob
+// into a regular expression class and returns how many bytes of glob it
+// spans. A ']' right after the opening bracket is literal, a backslash
+// escapes the next character, and POSIX classes such as [:alpha:] are kept.
+// Negated classes never match '/'.
+func bracketToRegexp(glob string) (string, int, error) {
+	var b strings.Builder
+	b.WriteByte('[')
+	i := 1
+	if i < len(glob) && (glob[i] == '!' || glob[i] == '^') {
+		b.WriteString("^/")
+		i++
+	}
+	for first := true; ; first = false {
+		if i >= len(glob) {
+			return "", 0, fmt.Errorf("unterminated character class")
+		}
+		c := glob[i]
+		switch {
+		case c == ']' && !first:
+			b.WriteByte(']')
+			return b.String(), i + 1, nil
+		case c == '[' && strings.HasPrefix(glob[i+1:], ":"):
+			end := strings.Index(glob[i+2:], ":]")
+			if end < 0 {
+				return "", 0, fmt.Errorf("unterminated character class")
+			}
+			name := glob[i+2 : i+2+end]
+			if !posixClasses[name] {
+				return "", 0, fmt.Errorf("unknown character class [:%s:]", name)
+			}
+			b.WriteString("[:" + name + ":]")
+			i += end + 4
+		case c == '\\' && i+1 < len(glob):
+			// An escaped character is always literal, even '-'
+			_, n := utf8.DecodeRuneInString(glob[i+1:])
+			b.WriteString(classLiteral(glob[i+1:i+1+n], `\[]^-`))
+			i += 1 + n
+		default:
+			_, n := utf8.DecodeRuneInString(glob[i:])
+			b.WriteString(classLiteral(glob[i:i+n], `\[]^`))
+			i += n
+		}
+	}
+}
+
+// classLiteral returns the character ch for use inside a regular expression
+// class, escaped if it is one of special.
+func classLiteral(ch, special string) string {
+	if len(ch) == 1 && strings.IndexByte(special, ch[0]) >= 0 {
+		return `\` + ch
+	}
+	return ch
+}
@@ -0,0 +1,109 @@
+package zipper
+
+import "testing"
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		// A pattern without a slash matches the name at any depth
+		{"*.log", "debug.log", false, true},
+		{"*.log", "a/b/debug.log", false, true},
+		{"*.log", "debug.log.txt", false, false},
+		{"debug?.log", "debug1.log", false, true},
+		{"debug?.log", "debug10.log", false, false},
+
+		// A slash anchors the pattern to the root
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"doc/*.txt", "doc/notes.txt", false, true},
+		{"doc/*.txt", "doc/sub/notes.txt", false, false},
+		{"doc/*.txt", "x/doc/notes.txt", false, false},
+
+		// A trailing slash only matches directories
+		{"logs/", "logs", true, true},
+		{"logs/", "logs", false, false},
+		{"logs/", "app/logs", true, true},
+
+		// "**" spans directories
+		{"**/foo", "foo", false, true},
+		{"**/foo", "a/b/foo", false, true},
+		{"**/foo/bar", "x/foo/bar", false, true},
+		{"abc/**", "abc/x", false, true},
+		{"abc/**", "abc/x/y", false, true},
+		{"abc/**", "abc", true, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "a/xb", false, false},
+		{"a**b", "a/b", false, false},
+
+		// Character classes
+		{"[abc].txt", "b.txt", false, true},
+		{"[abc].txt", "d.txt", false, false},
+		{"[a-c].txt", "b.txt", false, true},
+		{"[!a].txt", "a.txt", false, false},
+		{"[!a].txt", "b.txt", false, true},
+		{"[^a].txt", "b.txt", false, true},
+		{"x[!a]y", "x/y", false, false},
+		{"[]].txt", "].txt", false, true},
+		{"[!]].txt", "a.txt", false, true},
+		{"[!]].txt", "].txt", false, false},
+		{"[[:alpha:]].txt", "q.txt", false, true},
+		{"[[:alpha:]].txt", "1.txt", false, false},
+		{"[[:digit:]x].txt", "7.txt", false, true},
+		{"[[:digit:]x].txt", "x.txt", false, true},
+		{"[![:digit:]].txt", "7.txt", false, false},
+		{"[![:digit:]].txt", "a.txt", false, true},
+		{"[[:upper:][:digit:]]*", "Readme", false, true},
+		{"[[:upper:][:digit:]]*", "readme", false, false},
+		{"[[].txt", "[.txt", false, true},
+		{`[\]a].txt`, "].txt", false, true},
+		{`[a\-z].txt`, "-.txt", false, true},
+		{`[a\-z].txt`, "b.txt", false, false},
+		{`[\n].txt`, "n.txt", false, true},
+		{"[a^].txt", "^.txt", false, true},
+
+		// Characters of more than one byte
+		{"café*.txt", "café au lait.txt", false, true},
+		{"café*.txt", "cafe.txt", false, false},
+		{"日志.log", "logs/日志.log", false, true},
+		{"/文档/*.md", "文档/说明.md", false, true},
+		{"?.log", "日.log", false, true},
+		{"??.log", "日.log", false, false},
+		{"[日月].txt", "月.txt", false, true},
+		{"[日月].txt", "年.txt", false, false},
+		{"[!日].txt", "月.txt", false, true},
+		{"[!日].txt", "日.txt", false, false},
+		{"[à-ÿ]*", "élan", false, true},
+		{`\日志`, "日志", false, true},
+		{`[\é]x`, "éx", false, true},
+
+		// Escapes
+		{`\*.txt`, "*.txt", false, true},
+		{`\*.txt`, "a.txt", false, false},
+		{`\[a].txt`, "[a].txt", false, true},
+		{"a.b", "axb", false, false},
+	}
+	for _, tt := range tests {
+		p, err := compilePattern(tt.pattern)
+		if err != nil {
+			t.Errorf("compilePattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := p.match(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("%q matching %q (dir %v) = %v, want %v", tt.pattern, tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestPatternInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "/", "[abc", "[]", "[!]", "[[:alpha:]", "[[:alpha]]", "[[:nope:]]"} {
+		if _, err := compilePattern(pattern); err == nil {
+			t.Errorf("compilePattern(%q): expected an error", pattern)
+		}
+	}
+}
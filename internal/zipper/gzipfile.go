@@ -0,0 +1,300 @@
+package zipper
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CompressGzip compresses the single file srcPath into the gzip stream
+// gzPath, the way gzip(1) does, and writes its checksum to a .sha256 file.
+// The gzip header records the file's modification time, and its name if
+// that can be written in Latin-1. On failure, gzPath is removed.
+func CompressGzip(srcPath, gzPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	progress := opts.Progress
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return stats, err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return stats, err
+	}
+	if !info.Mode().IsRegular() {
+		return stats, fmt.Errorf("%s is not a regular file", srcPath)
+	}
+	stats.TotalBytes = info.Size()
+	stats.FileCount = 1
+
+	gzFile, err := os.Create(gzPath)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		gzFile.Close()
+		if err != nil {
+			// Do not leave a truncated file behind
+			os.Remove(gzPath)
+		}
+	}()
+
+	gzWriter, err := newParallelGzipWriter(gzFile, getOptimalCompressionLevel(stats.TotalBytes), getCompressorCount())
+	if err != nil {
+		return stats, err
+	}
+	// Stops the compressors if anything below fails; closing twice is harmless
+	defer gzWriter.Close()
+
+	// The header can only hold Latin-1 names. Without one, gunzip names the
+	// file after the archive, which is named after the file anyway.
+	if _, err := latin1(info.Name()); err == nil {
+		gzWriter.Name = info.Name()
+	}
+	gzWriter.ModTime = info.ModTime()
+	if norm := opts.normalizer(); norm != nil {
+		gzWriter.ModTime = norm.modTime
+	}
+
+	done := int64(0)
+	name := info.Name()
+	var r io.Reader = src
+	if progress != nil {
+		progress(0, stats.TotalBytes, name)
+		r = &progressReader{r: src, done: &done, total: stats.TotalBytes, progress: func(done, total int64) {
+			progress(done, total, name)
+		}}
+	}
+
+	if _, err := io.Copy(gzWriter, r); err != nil {
+		return stats, err
+	}
+	if err := gzWriter.Close(); err != nil {
+		return stats, err
+	}
+	if err := gzFile.Close(); err != nil {
+		return stats, err
+	}
+
+	stats.Checksum, err = calculateFileChecksum(gzPath)
+	if err != nil {
+		return stats, fmt.Errorf("checksum calculation failed: %w", err)
+	}
+	if err := writeChecksumFile(gzPath, stats.Checksum); err != nil {
+		return stats, fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return stats, nil
+}
+
+// DecompressGzip decompresses a gzip stream that holds a single file into
+// destDir, the way gunzip(1) does. The file is named after the gzip header,
+// or after the archive without its .gz extension if the header has no usable
+// name, and gets the modification time from the header. Progress reports the
+// compressed bytes read, since the size of the output is not known upfront.
+// If the Include and Exclude patterns or StripComponents leave the file out,
+// nothing is written.
+//
+// With Atomic, only the one file is replaced: it is decompressed next to its
+// destination and renamed into place once complete, and the rest of destDir
+// is left alone.
+func DecompressGzip(gzPath, destDir string, opts ExtractOptions) (stats ExtractStats, err error) {
+	if opts.Atomic && !opts.DryRun {
+		if err := checkAtomicPolicy(opts.Overwrite); err != nil {
+			return stats, err
+		}
+	}
+
+	progress := opts.Progress
+	restore := newMetadataRestorer(opts)
+	limiter := newExtractLimiter(opts.Limits)
+	out := &outputTracker{}
+	defer func() {
+		if err != nil {
+			out.cleanup()
+		}
+	}()
+
+	sel, err := newEntrySelector(opts)
+	if err != nil {
+		return stats, err
+	}
+
+	gzFile, err := os.Open(gzPath)
+	if err != nil {
+		return stats, err
+	}
+	defer gzFile.Close()
+
+	info, err := gzFile.Stat()
+	if err != nil {
+		return stats, err
+	}
+
+	done := int64(0)
+	compressed := &countingReader{r: &progressReader{r: gzFile, done: &done, total: info.Size(), progress: progress}}
+	gzReader, err := gzip.NewReader(compressed)
+	if err != nil {
+		return stats, err
+	}
+	defer gzReader.Close()
+
+	name := gzipFileName(gzReader.Header.Name, gzPath)
+	if !sel.match(name, false) {
+		return stats, nil
+	}
+	name, ok := opts.entryName(name)
+	if !ok {
+		return stats, nil
+	}
+	if !filepath.IsLocal(name) {
+		return stats, fmt.Errorf("invalid file path: %s", name)
+	}
+	if err := limiter.entry(name); err != nil {
+		return stats, err
+	}
+
+	conflicts := newConflictResolver(opts.Overwrite)
+	destPath, err := conflicts.resolve(filepath.Join(destDir, name), gzReader.Header.ModTime)
+	if err != nil {
+		return stats, err
+	}
+	stats.Conflicts = conflicts.list()
+	if opts.DryRun {
+		// The size is only known after decompressing
+		stats.FileCount = 1
+		return stats, nil
+	}
+	if destPath == "" {
+		// The existing file is kept
+		return stats, nil
+	}
+	if progress != nil {
+		progress(0, info.Size())
+	}
+
+	if err := out.mkdirAll(destDir); err != nil {
+		return stats, err
+	}
+	writePath := destPath
+	var outFile *os.File
+	if opts.Atomic {
+		existing, statErr := os.Lstat(destPath)
+		if statErr == nil && existing.IsDir() {
+			return stats, fmt.Errorf("%s is a directory", destPath)
+		}
+		if outFile, err = createStaged(destPath); err != nil {
+			return stats, err
+		}
+		writePath = outFile.Name()
+		defer func() {
+			if err != nil {
+				os.Remove(writePath)
+			}
+		}()
+	} else {
+		out.create(destPath)
+		if outFile, err = os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			return stats, err
+		}
+	}
+
+	// The ratio is checked on the stream as a whole
+	written, err := io.Copy(limiter.writer(outFile, name, -1), limiter.stream(gzReader, compressed))
+	if err != nil {
+		outFile.Close()
+		return stats, err
+	}
+	if err := outFile.Close(); err != nil {
+		return stats, err
+	}
+	if err := restore.file(entryMeta{path: writePath, modTime: gzReader.Header.ModTime}); err != nil {
+		return stats, err
+	}
+	if writePath != destPath {
+		_, statErr := os.Lstat(destPath)
+		if stats.Backup, err = swapIntoPlace(writePath, destPath, statErr == nil); err != nil {
+			return stats, err
+		}
+	}
+
+	stats.TotalBytes = written
+	stats.FileCount = 1
+	return stats, nil
+}
+
+// gzipFileName returns the name to decompress the gzip stream at gzPath to.
+// The name in the header is only used if it is a plain file name.
+func gzipFileName(headerName, gzPath string) string {
+	if name := path.Base(filepath.ToSlash(headerName)); filepath.IsLocal(name) && name != "." {
+		return name
+	}
+
+	base := filepath.Base(gzPath)
+	if trimmed, ext := SplitArchiveExt(base); strings.EqualFold(ext, ".gz") {
+		return trimmed
+	}
+	return base + ".out"
+}
+
+// listGzip describes the single file held in a gzip stream. Its size is only
+// known after decompressing the whole stream.
+func listGzip(gzPath string) ([]Entry, error) {
+	file, err := os.Open(gzPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gzReader.Close()
+
+	size, err := io.Copy(io.Discard, gzReader)
+	if err != nil {
+		return nil, err
+	}
+	return []Entry{{
+		Name:           gzipFileName(gzReader.Header.Name, gzPath),
+		Size:           size,
+		CompressedSize: info.Size(),
+		Method:         "gzip",
+		Modified:       gzReader.Header.ModTime,
+		Mode:           0644,
+	}}, nil
+}
+
+// testGzip decompresses a gzip stream that holds a single file, checking
+// its CRC-32 and size.
+func testGzip(gzPath string, progress ProgressFunc) (stats ExtractStats, err error) {
+	file, err := os.Open(gzPath)
+	if err != nil {
+		return stats, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return stats, err
+	}
+	done := int64(0)
+	gzReader, err := gzip.NewReader(&progressReader{r: file, done: &done, total: info.Size(), progress: progress})
+	if err != nil {
+		return stats, err
+	}
+	defer gzReader.Close()
+
+	stats.TotalBytes, err = io.Copy(io.Discard, gzReader)
+	stats.FileCount = 1
+	return stats, err
+}
@@ -0,0 +1,71 @@
+package zipper
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompressGzipHeaderName(t *testing.T) {
+	tests := []struct {
+		name       string
+		headerName string
+	}{
+		{"access.log", "access.log"},
+		{"café.txt", "café.txt"},
+		// Not Latin-1, so left out of the header
+		{"日志.log", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, map[string]string{tt.name: "line\n"})
+			gzPath := filepath.Join(root, tt.name+".gz")
+			if _, err := CompressGzip(filepath.Join(root, tt.name), gzPath, CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(gzPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if zr.Name != tt.headerName {
+				t.Errorf("header name = %q, want %q", zr.Name, tt.headerName)
+			}
+
+			// Either way the file comes back under its own name
+			destDir := filepath.Join(root, "out")
+			if _, err := DecompressGzip(gzPath, destDir, ExtractOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := os.ReadFile(filepath.Join(destDir, tt.name)); err != nil || string(got) != "line\n" {
+				t.Errorf("%s = %q, %v", tt.name, got, err)
+			}
+		})
+	}
+}
+
+func TestCompressGzipFailureRemovesOutput(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"data.csv": "1,2,3\n"})
+	gzPath := filepath.Join(root, "data.csv.gz")
+
+	// A directory in the way of the checksum file fails the last step
+	if err := os.Mkdir(gzPath+".sha256", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompressGzip(filepath.Join(root, "data.csv"), gzPath, CreateOptions{}); err == nil {
+		t.Fatal("CompressGzip succeeded without writing its checksum file")
+	}
+	want := []string{"data.csv", "data.csv.gz.sha256"}
+	if got := listTree(t, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s holds %q, want %q", root, got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package zipper
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// fileKey identifies a file on disk independently of the paths that lead to it.
+type fileKey struct {
+	dev, ino uint64
+}
+
+// markHardLinks finds files that are hard links to a file earlier in files
+// and points them at the first copy, so its data is stored only once. With
+// asSymlinks the repeats become relative symlinks, for formats that have no
+// hard links of their own.
+func markHardLinks(files []fileJob, asSymlinks bool) {
+	first := make(map[fileKey]string)
+	for i := range files {
+		job := &files[i]
+		if !job.isRegular() {
+			continue
+		}
+		key, ok := hardLinkKey(job.info)
+		if !ok {
+			continue
+		}
+		rel := filepath.ToSlash(job.rel)
+		target, seen := first[key]
+		if !seen {
+			first[key] = rel
+			continue
+		}
+		if asSymlinks {
+			job.link = relativeLink(rel, target)
+		} else {
+			job.hardLink = target
+		}
+	}
+}
+
+// relativeLink returns the symlink target that leads from the entry from to
+// the entry to. Both are slash-separated paths relative to the archive root.
+func relativeLink(from, to string) string {
+	rel, err := filepath.Rel(path.Dir(from), to)
+	if err != nil {
+		return to
+	}
+	return filepath.ToSlash(rel)
+}
+
+// createHardLink recreates the hard link name -> target below destDir. The
+// target must be an entry extracted earlier, reached without following links.
+// An existing file in place of the link is dealt with by conflicts.
+func createHardLink(destDir, name, target string, modTime time.Time, out *outputTracker, conflicts *conflictResolver) error {
+	if !filepath.IsLocal(target) {
+		return fmt.Errorf("invalid hard link target: %s -> %s", name, target)
+	}
+	for _, p := range []string{name, target} {
+		parent, err := symlinkParent(destDir, p)
+		if err != nil {
+			return err
+		}
+		if parent != "" {
+			return fmt.Errorf("refusing hard link %s -> %s: %s is a symlink", name, target, parent)
+		}
+	}
+
+	// The target may have been written under another name
+	targetPath := conflicts.path(filepath.Join(destDir, filepath.FromSlash(target)))
+	info, err := os.Lstat(targetPath)
+	if err != nil {
+		return fmt.Errorf("hard link %s -> %s: %w", name, target, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("refusing hard link %s -> %s: target is not a regular file", name, target)
+	}
+
+	linkPath, err := conflicts.resolve(filepath.Join(destDir, filepath.FromSlash(name)), modTime)
+	if err != nil || linkPath == "" {
+		return err
+	}
+	if err := out.mkdirAll(filepath.Dir(linkPath)); err != nil {
+		return err
+	}
+	// Replace an existing file rather than writing through it
+	out.create(linkPath)
+	if err := os.Remove(linkPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(targetPath, linkPath)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package zipper
+
+import "io/fs"
+
+// hardLinkKey reports false: file identities are not available from
+// fs.FileInfo on this platform, so every copy is stored in full.
+func hardLinkKey(info fs.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
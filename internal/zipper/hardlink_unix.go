@@ -0,0 +1,17 @@
+//go:build unix
+
+package zipper
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// hardLinkKey returns the device and inode of a file with more than one link.
+func hardLinkKey(info fs.FileInfo) (fileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
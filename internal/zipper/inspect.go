@@ -0,0 +1,258 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry describes one file, directory or link stored in an archive.
+type Entry struct {
+	Name string
+	// Size is the uncompressed size in bytes.
+	Size int64
+	// CompressedSize is the stored size in bytes, or -1 if the format does
+	// not record it per entry (a compressed tar compresses the stream as a whole).
+	CompressedSize int64
+	// Method is the compression method of the entry, such as "deflate" or
+	// "store" in a zip, or the whole-stream compression of a tar, such as "gzip".
+	Method   string
+	Modified time.Time
+	Mode     fs.FileMode
+}
+
+// IsDir reports whether the entry is a directory.
+func (e Entry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// ListArchive returns the entries of a zip or tar archive in stored order, or
+// the file held in a plain gzip stream. The format is recognised by content,
+// not by name.
+func ListArchive(archivePath string) ([]Entry, error) {
+	info, err := readableArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case info.Format == FormatZip:
+		return listZip(archivePath)
+	case info.Format == FormatGzip && !info.Tar:
+		return listGzip(archivePath)
+	}
+	return listTar(archivePath, info.Format)
+}
+
+func listZip(zipPath string) ([]Entry, error) {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	entries := make([]Entry, 0, len(reader.File))
+	for _, f := range reader.File {
+		entries = append(entries, Entry{
+			Name:           f.Name,
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: int64(f.CompressedSize64),
+			Method:         zipMethodName(f.Method),
+			Modified:       f.Modified,
+			Mode:           f.Mode(),
+		})
+	}
+	return entries, nil
+}
+
+func zipMethodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return "store"
+	case zip.Deflate:
+		return "deflate"
+	default:
+		return fmt.Sprintf("method %d", method)
+	}
+}
+
+func listTar(archivePath string, format Format) ([]Entry, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	err = walkTar(file, format, func(header *tar.Header, _ io.Reader) error {
+		entries = append(entries, Entry{
+			Name:           header.Name,
+			Size:           header.Size,
+			CompressedSize: -1,
+			Method:         format.String(),
+			Modified:       header.ModTime,
+			Mode:           header.FileInfo().Mode(),
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// walkTar calls fn for every entry of the tar archive in r, a stream of
+// format. The reader passed to fn yields the entry's data until fn returns.
+func walkTar(r io.Reader, format Format, fn func(header *tar.Header, data io.Reader) error) error {
+	stream, err := openTarStream(r, format)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	tarReader := tar.NewReader(stream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(header, tarReader); err != nil {
+			return err
+		}
+	}
+
+	// Read to the end so the stream's trailing checksum is checked too
+	_, err = io.Copy(io.Discard, stream)
+	return err
+}
+
+// TestArchive decompresses every entry of a zip or tar archive, or the file
+// in a plain gzip stream, without writing anything to disk, checking each zip
+// entry's CRC-32 or the checksums of the compressed stream. The first corrupt
+// entry is reported as an error.
+func TestArchive(archivePath string, progress ProgressFunc) (stats ExtractStats, err error) {
+	info, err := readableArchive(archivePath)
+	if err != nil {
+		return stats, err
+	}
+	switch {
+	case info.Format == FormatZip:
+		return testZip(archivePath, progress)
+	case info.Format == FormatGzip && !info.Tar:
+		return testGzip(archivePath, progress)
+	}
+	return testTar(archivePath, info.Format, progress)
+}
+
+func testZip(zipPath string, progress ProgressFunc) (stats ExtractStats, err error) {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return stats, err
+	}
+	defer reader.Close()
+
+	for _, f := range reader.File {
+		if !f.FileInfo().IsDir() {
+			stats.TotalBytes += int64(f.UncompressedSize64)
+			stats.FileCount++
+		}
+	}
+
+	done := int64(0)
+	var doneMutex sync.Mutex
+	callProgress := func() {
+		if progress != nil {
+			doneMutex.Lock()
+			progress(done, stats.TotalBytes)
+			doneMutex.Unlock()
+		}
+	}
+	callProgress()
+
+	// Check entries in parallel; zip.File readers verify the CRC at EOF
+	jobChan := make(chan *zip.File)
+	errChan := make(chan error, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < getWorkerCount(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, streamBufferSize)
+			for f := range jobChan {
+				rc, err := f.Open()
+				if err == nil {
+					var n int64
+					n, err = io.CopyBuffer(io.Discard, rc, buf)
+					rc.Close()
+					doneMutex.Lock()
+					done += n
+					doneMutex.Unlock()
+					callProgress()
+				}
+				if err != nil {
+					select {
+					case errChan <- fmt.Errorf("%s: %w", f.Name, err):
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		select {
+		case err := <-errChan:
+			close(jobChan)
+			wg.Wait()
+			return stats, err
+		case jobChan <- f:
+		}
+	}
+	close(jobChan)
+	wg.Wait()
+
+	select {
+	case err := <-errChan:
+		return stats, err
+	default:
+	}
+	return stats, nil
+}
+
+func testTar(archivePath string, format Format, progress ProgressFunc) (stats ExtractStats, err error) {
+	// The total is unknown without reading the archive twice, so progress
+	// reports the compressed bytes read instead
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return stats, err
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return stats, err
+	}
+	defer file.Close()
+
+	done := int64(0)
+	buf := make([]byte, streamBufferSize)
+	r := &progressReader{r: file, done: &done, total: info.Size(), progress: progress}
+	err = walkTar(r, format, func(header *tar.Header, data io.Reader) error {
+		n, err := io.CopyBuffer(io.Discard, data, buf)
+		if err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			stats.TotalBytes += n
+			stats.FileCount++
+		}
+		return nil
+	})
+	return stats, err
+}
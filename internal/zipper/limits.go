@@ -0,0 +1,237 @@
+package zipper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ExtractLimits bounds what an extraction may produce, to protect against zip
+// bombs and other hostile archives. A zero field means no limit.
+//
+// Sizes are enforced on the bytes actually decompressed, not only on the sizes
+// an archive declares: the declared sizes merely let an obviously oversized
+// archive fail before anything is written.
+type ExtractLimits struct {
+	// MaxTotalBytes limits the bytes written to all extracted files together.
+	MaxTotalBytes int64
+	// MaxEntries limits the number of entries, including directories and links.
+	MaxEntries int
+	// MaxFileSize limits the bytes written to a single file.
+	MaxFileSize int64
+	// MaxRatio limits how many bytes may be decompressed per compressed byte,
+	// per zip entry or over the whole stream of a tar.gz.
+	MaxRatio float64
+	// MaxDepth limits the number of elements in an entry name, so "a/b/c.txt"
+	// has a depth of 3.
+	MaxDepth int
+}
+
+// ErrLimitExceeded is matched by every LimitError.
+var ErrLimitExceeded = errors.New("extraction limit exceeded")
+
+// LimitError reports that an archive exceeded one of its ExtractLimits.
+// Extraction stops and removes what it created.
+type LimitError struct {
+	// Limit names the limit: "total size", "entries", "file size",
+	// "compression ratio" or "path depth".
+	Limit string
+	// Name is the entry being extracted, or "" for the archive as a whole.
+	Name string
+	// Max is the configured value of the limit.
+	Max float64
+}
+
+func (e *LimitError) Error() string {
+	msg := fmt.Sprintf("%s limit of %s exceeded", e.Limit, strconv.FormatFloat(e.Max, 'f', -1, 64))
+	if e.Name != "" {
+		return e.Name + ": " + msg
+	}
+	return msg
+}
+
+// Is makes errors.Is(err, ErrLimitExceeded) report true.
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// ratioGrace is the output below which MaxRatio is not checked. Small files
+// of zeros or repeated lines compress far better than any sensible limit.
+const ratioGrace = 1 << 20
+
+// extractLimiter enforces ExtractLimits. Entries are checked once, while the
+// archive is scanned; output is checked by the writers it hands out, which
+// may be used from several goroutines.
+type extractLimiter struct {
+	limits   ExtractLimits
+	entries  int
+	declared int64
+	written  atomic.Int64
+}
+
+func newExtractLimiter(limits ExtractLimits) *extractLimiter {
+	return &extractLimiter{limits: limits}
+}
+
+// entry counts an entry and checks the depth of its name.
+func (l *extractLimiter) entry(name string) error {
+	l.entries++
+	if limit := l.limits.MaxEntries; limit > 0 && l.entries > limit {
+		return &LimitError{Limit: "entries", Max: float64(limit)}
+	}
+	if limit := l.limits.MaxDepth; limit > 0 {
+		clean := strings.Trim(path.Clean(filepath.ToSlash(name)), "/")
+		if depth := strings.Count(clean, "/") + 1; depth > limit {
+			return &LimitError{Limit: "path depth", Name: name, Max: float64(limit)}
+		}
+	}
+	return nil
+}
+
+// declare checks the size an archive declares for an entry.
+func (l *extractLimiter) declare(name string, size int64) error {
+	if limit := l.limits.MaxFileSize; limit > 0 && size > limit {
+		return &LimitError{Limit: "file size", Name: name, Max: float64(limit)}
+	}
+	l.declared += size
+	if limit := l.limits.MaxTotalBytes; limit > 0 && l.declared > limit {
+		return &LimitError{Limit: "total size", Name: name, Max: float64(limit)}
+	}
+	return nil
+}
+
+// writer wraps w, the output of entry name, to enforce the size limits and,
+// if compressed is not negative, the ratio against that many compressed bytes.
+func (l *extractLimiter) writer(w io.Writer, name string, compressed int64) io.Writer {
+	return &limitedOutput{w: w, l: l, name: name, compressed: compressed}
+}
+
+// ratio checks the ratio of out bytes decompressed from in compressed bytes.
+func (l *extractLimiter) ratio(name string, out, in int64) error {
+	limit := l.limits.MaxRatio
+	if limit <= 0 || out <= ratioGrace {
+		return nil
+	}
+	if in <= 0 || float64(out)/float64(in) > limit {
+		return &LimitError{Limit: "compression ratio", Name: name, Max: limit}
+	}
+	return nil
+}
+
+type limitedOutput struct {
+	w          io.Writer
+	l          *extractLimiter
+	name       string
+	compressed int64
+	n          int64
+}
+
+func (o *limitedOutput) Write(p []byte) (int, error) {
+	n := int64(len(p))
+	limits := o.l.limits
+	if limit := limits.MaxFileSize; limit > 0 && o.n+n > limit {
+		return 0, &LimitError{Limit: "file size", Name: o.name, Max: float64(limit)}
+	}
+	if limit := limits.MaxTotalBytes; limit > 0 && o.l.written.Add(n) > limit {
+		return 0, &LimitError{Limit: "total size", Name: o.name, Max: float64(limit)}
+	}
+	o.n += n
+	if o.compressed >= 0 {
+		if err := o.l.ratio(o.name, o.n, o.compressed); err != nil {
+			return 0, err
+		}
+	}
+	return o.w.Write(p)
+}
+
+// stream wraps the decompressed stream r of an archive whose compressed bytes
+// are read through in, checking the ratio of the archive as a whole.
+func (l *extractLimiter) stream(r io.Reader, in *countingReader) io.Reader {
+	return &ratioReader{r: r, in: in, l: l}
+}
+
+type ratioReader struct {
+	r  io.Reader
+	in *countingReader
+	l  *extractLimiter
+	n  int64
+}
+
+func (r *ratioReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err := r.l.ratio("", r.n, r.in.n); err != nil {
+		return 0, err
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// outputTracker records the files and directories an extraction creates, so
+// a failed extraction can remove them again. Files that existed beforehand are
+// left alone, even if they were already overwritten.
+type outputTracker struct {
+	mu      sync.Mutex
+	created []string
+}
+
+// create records path if nothing exists there yet. Call it before creating path.
+func (t *outputTracker) create(path string) {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		t.mu.Lock()
+		t.created = append(t.created, path)
+		t.mu.Unlock()
+	}
+}
+
+// mkdirAll creates dir and any missing parents, recording those it creates.
+func (t *outputTracker) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); !os.IsNotExist(err) {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	for i := len(missing) - 1; i >= 0; i-- {
+		t.created = append(t.created, missing[i])
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// cleanup removes everything recorded, newest first.
+func (t *outputTracker) cleanup() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.created) - 1; i >= 0; i-- {
+		os.RemoveAll(t.created[i])
+	}
+	t.created = nil
+}
@@ -0,0 +1,368 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// hostileEntry is one entry of an archive built by a test. At most one of
+// symlink and hardLink is set.
+type hostileEntry struct {
+	name     string
+	body     string
+	symlink  string
+	hardLink string // tar only
+}
+
+func writeZipEntries(t *testing.T, path string, entries []hostileEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		if e.symlink != "" {
+			h.SetMode(fs.ModeSymlink | 0777)
+			body = e.symlink
+		}
+		fw, err := w.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGzEntries(t *testing.T, path string, entries []hostileEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.symlink != "":
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, e.symlink, 0
+		case e.hardLink != "":
+			h.Typeflag, h.Linkname, h.Size = tar.TypeLink, e.hardLink, 0
+		}
+		if err := w.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 0 {
+			if _, err := w.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listTree returns the slash-separated paths of everything below dir.
+func listTree(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir {
+			rel, _ := filepath.Rel(dir, p)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestExtractHostileArchives(t *testing.T) {
+	zeros := strings.Repeat("\x00", 4<<20)
+	// outside stands for a file the archive must not reach; links to it are
+	// given as "../outside/victim.txt" from the destination "out"
+	tests := []struct {
+		name    string
+		tar     bool
+		entries []hostileEntry
+		limits  ExtractLimits
+		// wantLimit is the limit expected to stop extraction, "" if another
+		// error is expected
+		wantLimit string
+		// kept is what the destination holds after a successful extraction;
+		// nil means extraction must fail and leave nothing behind
+		kept []string
+	}{
+		{
+			name:    "zip parent directory name",
+			entries: []hostileEntry{{name: "ok/a.txt", body: "a"}, {name: "../evil.txt", body: "x"}},
+		},
+		{
+			name:    "zip nested parent directory name",
+			entries: []hostileEntry{{name: "a/../../evil.txt", body: "x"}},
+		},
+		{
+			name:    "zip absolute name",
+			entries: []hostileEntry{{name: "/tmp/evil.txt", body: "x"}},
+		},
+		{
+			name:    "tar parent directory name",
+			tar:     true,
+			entries: []hostileEntry{{name: "ok/a.txt", body: "a"}, {name: "../evil.txt", body: "x"}},
+		},
+		{
+			name:    "tar absolute name",
+			tar:     true,
+			entries: []hostileEntry{{name: "/tmp/evil.txt", body: "x"}},
+		},
+		{
+			name:    "zip absolute symlink",
+			entries: []hostileEntry{{name: "a.txt", body: "a"}, {name: "link", symlink: "OUTSIDE/victim.txt"}},
+			kept:    []string{"a.txt"},
+		},
+		{
+			name:    "zip escaping symlink",
+			entries: []hostileEntry{{name: "a.txt", body: "a"}, {name: "dir/link", symlink: "../../outside/victim.txt"}},
+			kept:    []string{"a.txt"},
+		},
+		{
+			name: "tar escaping symlink written through",
+			tar:  true,
+			entries: []hostileEntry{
+				{name: "link", symlink: "../outside"},
+				{name: "link/victim.txt", body: "overwritten"},
+			},
+			// The link is skipped, so the file goes into a real directory
+			kept: []string{"link", "link/victim.txt"},
+		},
+		{
+			name: "zip symlink through an earlier symlink",
+			entries: []hostileEntry{
+				{name: "sub/f", body: "f"},
+				{name: "sub/l2", symlink: ".."},
+				{name: "l1", symlink: "sub/l2/../.."},
+			},
+			kept: []string{"sub", "sub/f", "sub/l2"},
+		},
+		{
+			name: "tar symlink through a later symlink",
+			tar:  true,
+			entries: []hostileEntry{
+				{name: "sub/f", body: "f"},
+				{name: "l1", symlink: "sub/l2/../../outside/victim.txt"},
+				{name: "sub/l2", symlink: ".."},
+			},
+			kept: []string{"sub", "sub/f", "sub/l2"},
+		},
+		{
+			name: "tar symlink through an existing symlink",
+			tar:  true,
+			entries: []hostileEntry{
+				{name: "sub/l2", symlink: ".."},
+				{name: "l1", symlink: "sub/l2/x"},
+			},
+			kept: []string{"sub", "sub/l2"},
+		},
+		{
+			name: "tar safe symlink chain",
+			tar:  true,
+			entries: []hostileEntry{
+				{name: "b", body: "b"},
+				{name: "a", symlink: "./b"},
+				{name: "dir/c", symlink: "../a"},
+			},
+			kept: []string{"a", "b", "dir", "dir/c"},
+		},
+		{
+			name:    "tar absolute symlink",
+			tar:     true,
+			entries: []hostileEntry{{name: "a.txt", body: "a"}, {name: "link", symlink: "OUTSIDE"}},
+			kept:    []string{"a.txt"},
+		},
+		{
+			name:    "tar hard link outside the destination",
+			tar:     true,
+			entries: []hostileEntry{{name: "a.txt", body: "a"}, {name: "link", hardLink: "../outside/victim.txt"}},
+		},
+		{
+			name:    "tar absolute hard link",
+			tar:     true,
+			entries: []hostileEntry{{name: "link", hardLink: "OUTSIDE/victim.txt"}},
+		},
+		{
+			name:    "tar hard link to a missing entry",
+			tar:     true,
+			entries: []hostileEntry{{name: "link", hardLink: "victim.txt"}},
+		},
+		{
+			name:      "zip high ratio",
+			entries:   []hostileEntry{{name: "a.txt", body: "a"}, {name: "bomb", body: zeros}},
+			limits:    ExtractLimits{MaxRatio: 100},
+			wantLimit: "compression ratio",
+		},
+		{
+			name:      "tar.gz high ratio",
+			tar:       true,
+			entries:   []hostileEntry{{name: "a.txt", body: "a"}, {name: "bomb", body: zeros}},
+			limits:    ExtractLimits{MaxRatio: 100},
+			wantLimit: "compression ratio",
+		},
+		{
+			name:      "zip file size",
+			entries:   []hostileEntry{{name: "big", body: zeros}},
+			limits:    ExtractLimits{MaxFileSize: 1 << 20},
+			wantLimit: "file size",
+		},
+		{
+			name:      "tar file size",
+			tar:       true,
+			entries:   []hostileEntry{{name: "a.txt", body: "a"}, {name: "big", body: zeros}},
+			limits:    ExtractLimits{MaxFileSize: 1 << 20},
+			wantLimit: "file size",
+		},
+		{
+			name:      "zip total size",
+			entries:   []hostileEntry{{name: "a", body: zeros}, {name: "b", body: zeros}},
+			limits:    ExtractLimits{MaxTotalBytes: 6 << 20},
+			wantLimit: "total size",
+		},
+		{
+			name:      "tar total size",
+			tar:       true,
+			entries:   []hostileEntry{{name: "a", body: zeros}, {name: "b", body: zeros}},
+			limits:    ExtractLimits{MaxTotalBytes: 6 << 20},
+			wantLimit: "total size",
+		},
+		{
+			name:      "zip entries",
+			entries:   []hostileEntry{{name: "a"}, {name: "b"}, {name: "c"}},
+			limits:    ExtractLimits{MaxEntries: 2},
+			wantLimit: "entries",
+		},
+		{
+			name:      "tar entries",
+			tar:       true,
+			entries:   []hostileEntry{{name: "a"}, {name: "b"}, {name: "c"}},
+			limits:    ExtractLimits{MaxEntries: 2},
+			wantLimit: "entries",
+		},
+		{
+			name:      "zip depth",
+			entries:   []hostileEntry{{name: "a/b/c/d.txt", body: "x"}},
+			limits:    ExtractLimits{MaxDepth: 3},
+			wantLimit: "path depth",
+		},
+		{
+			name:      "tar depth",
+			tar:       true,
+			entries:   []hostileEntry{{name: "a.txt", body: "a"}, {name: "a/b/c/d.txt", body: "x"}},
+			limits:    ExtractLimits{MaxDepth: 3},
+			wantLimit: "path depth",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			outside := filepath.Join(root, "outside")
+			victim := filepath.Join(outside, "victim.txt")
+			if err := os.Mkdir(outside, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(victim, []byte("original"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			entries := make([]hostileEntry, len(tt.entries))
+			for i, e := range tt.entries {
+				e.symlink = strings.Replace(e.symlink, "OUTSIDE", outside, 1)
+				e.hardLink = strings.Replace(e.hardLink, "OUTSIDE", outside, 1)
+				entries[i] = e
+			}
+			archivePath := filepath.Join(root, "archive")
+			if tt.tar {
+				writeTarGzEntries(t, archivePath, entries)
+			} else {
+				writeZipEntries(t, archivePath, entries)
+			}
+
+			destDir := filepath.Join(root, "out")
+			_, err := ExtractArchive(archivePath, destDir, ExtractOptions{Limits: tt.limits})
+
+			switch {
+			case tt.kept != nil:
+				if err != nil {
+					t.Fatalf("extraction failed: %v", err)
+				}
+				if got := listTree(t, destDir); strings.Join(got, ",") != strings.Join(tt.kept, ",") {
+					t.Errorf("destination holds %q, want %q", got, tt.kept)
+				}
+			case err == nil:
+				t.Fatal("extraction succeeded")
+			case tt.wantLimit != "":
+				var limitErr *LimitError
+				if !errors.Is(err, ErrLimitExceeded) || !errors.As(err, &limitErr) {
+					t.Fatalf("error %v is not a LimitError", err)
+				}
+				if limitErr.Limit != tt.wantLimit {
+					t.Errorf("limit %q exceeded, want %q", limitErr.Limit, tt.wantLimit)
+				}
+			case errors.Is(err, ErrLimitExceeded):
+				t.Errorf("unexpected limit error: %v", err)
+			}
+
+			if tt.kept == nil {
+				if _, err := os.Lstat(destDir); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("destination left behind: %q", listTree(t, destDir))
+				}
+			}
+			want := append([]string{"archive", "outside", "outside/victim.txt"}, listPrefixed("out", tt.kept)...)
+			sort.Strings(want)
+			if got := listTree(t, root); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("%s holds %q, want %q", root, got, want)
+			}
+			if data, err := os.ReadFile(victim); err != nil || string(data) != "original" {
+				t.Errorf("victim file = %q, %v", data, err)
+			}
+		})
+	}
+}
+
+// listPrefixed returns dir followed by the names below it, as listTree
+// would list them from dir's parent.
+func listPrefixed(dir string, names []string) []string {
+	if names == nil {
+		return nil
+	}
+	list := []string{dir}
+	for _, name := range names {
+		list = append(list, dir+"/"+name)
+	}
+	return list
+}
@@ -0,0 +1,211 @@
+package zipper
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExtractOptions controls how archives are extracted. The zero value restores
+// file metadata, enforces no limits and reports no progress.
+type ExtractOptions struct {
+	// NoPreserve skips restoring modification times, exact permissions and
+	// ownership. Files are then created with the archived permissions minus
+	// the umask and directories with the default mode.
+	NoPreserve bool
+
+	// Limits bounds the size of the output, to refuse zip bombs.
+	Limits ExtractLimits
+
+	// Include, if not empty, extracts only the entries matching one of these
+	// patterns and everything inside a matching directory. Exclude leaves
+	// out matching entries the same way. Both use the glob syntax of
+	// ExcludePolicy.
+	Include []string
+	Exclude []string
+
+	// StripComponents removes this many leading path elements from entry
+	// names, and skips entries that have no more elements than that. Rename
+	// then maps name prefixes; the first matching rule applies. Include and
+	// Exclude match the names as they are stored in the archive.
+	StripComponents int
+	Rename          []RenameRule
+
+	// Overwrite decides what happens to files that already exist in the
+	// destination. DryRun resolves those conflicts and reports them in
+	// ExtractStats without writing anything.
+	Overwrite OverwritePolicy
+	DryRun    bool
+
+	// Atomic extracts into a staging directory next to the destination and
+	// only moves it into place once every entry has been extracted and
+	// checked. An existing destination is renamed to a backup first. If
+	// extraction fails, the destination is left untouched. Atomic replaces
+	// the destination as a whole, so Overwrite must be OverwriteReplace. For
+	// a gzip stream holding a single file, only that file is replaced.
+	Atomic bool
+
+	// Progress, if set, receives progress updates.
+	Progress ProgressFunc
+}
+
+// entryMeta is the metadata of an extracted entry.
+type entryMeta struct {
+	path     string
+	mode     fs.FileMode
+	modTime  time.Time
+	uid, gid int
+	hasOwner bool
+	xattrs   map[string]string
+}
+
+// metadataRestorer applies archived modes, times and ownership to extracted
+// entries. Files are restored as soon as they are written; directories are
+// restored at the end, deepest first, so writing their children neither
+// fails on a read-only mode nor bumps their mtime afterwards.
+type metadataRestorer struct {
+	enabled bool
+	chown   bool
+
+	mu          sync.Mutex
+	dirs        []entryMeta
+	xattrWarned bool
+}
+
+func newMetadataRestorer(opts ExtractOptions) *metadataRestorer {
+	return &metadataRestorer{
+		enabled: !opts.NoPreserve,
+		// Only root may give files away; Geteuid is -1 on Windows
+		chown: !opts.NoPreserve && os.Geteuid() == 0,
+	}
+}
+
+// file restores the metadata of a regular file that has been fully written.
+func (r *metadataRestorer) file(m entryMeta) error {
+	if !r.enabled {
+		return nil
+	}
+	return r.apply(m)
+}
+
+// dir records the metadata of a directory for finish.
+func (r *metadataRestorer) dir(m entryMeta) {
+	if !r.enabled {
+		return
+	}
+	r.mu.Lock()
+	r.dirs = append(r.dirs, m)
+	r.mu.Unlock()
+}
+
+// link restores the ownership of a symlink. Its mode and times are left
+// alone, since changing them would affect the file it points to.
+func (r *metadataRestorer) link(m entryMeta) error {
+	if !r.chown || !m.hasOwner {
+		return nil
+	}
+	return os.Lchown(m.path, m.uid, m.gid)
+}
+
+// finish restores the recorded directories once everything inside them exists.
+func (r *metadataRestorer) finish() error {
+	r.mu.Lock()
+	dirs := r.dirs
+	r.dirs = nil
+	r.mu.Unlock()
+
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i].path, string(os.PathSeparator)) > strings.Count(dirs[j].path, string(os.PathSeparator))
+	})
+	for _, m := range dirs {
+		if err := r.apply(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *metadataRestorer) apply(m entryMeta) error {
+	// Change the owner first; chown clears the setuid and setgid bits
+	if r.chown && m.hasOwner {
+		if err := os.Lchown(m.path, m.uid, m.gid); err != nil {
+			return err
+		}
+	}
+	// Before chmod, which may make the entry read-only, and after chown,
+	// which clears file capabilities
+	r.restoreXattrs(m)
+
+	// Archives written without Unix modes carry no permission bits at all;
+	// keep the defaults rather than making the entry inaccessible
+	if m.mode.Perm() != 0 {
+		if err := os.Chmod(m.path, m.mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return err
+		}
+	}
+	if !m.modTime.IsZero() {
+		// A zero access time leaves it unchanged
+		if err := os.Chtimes(m.path, time.Time{}, m.modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreXattrs sets the extended attributes of m. Failures do not stop the
+// extraction; the first one is reported as a warning.
+func (r *metadataRestorer) restoreXattrs(m entryMeta) {
+	for name, value := range m.xattrs {
+		err := writeXattr(m.path, name, value)
+		if err == nil {
+			continue
+		}
+
+		r.mu.Lock()
+		warned := r.xattrWarned
+		r.xattrWarned = true
+		r.mu.Unlock()
+		if warned {
+			return
+		}
+		if isXattrUnsupported(err) {
+			fmt.Fprintf(os.Stderr, "Warning: extended attributes not restored, the destination does not support them: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: could not restore extended attribute %s on %s: %v\n", name, m.path, err)
+		}
+		return
+	}
+}
+
+// zipModTime returns the modification time of a zip entry. The Info-ZIP
+// extended timestamp is exact; without one only the MS-DOS time is stored,
+// which by convention is local time rather than the UTC archive/zip assumes.
+func zipModTime(f *zip.File) time.Time {
+	if f.Modified.IsZero() || hasZipExtra(f.Extra, 0x5455) {
+		return f.Modified
+	}
+	t := f.Modified
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+}
+
+// hasZipExtra reports whether the extra field data contains a block with id.
+func hasZipExtra(extra []byte, id uint16) bool {
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if tag == id {
+			return true
+		}
+		if 4+size > len(extra) {
+			break
+		}
+		extra = extra[4+size:]
+	}
+	return false
+}
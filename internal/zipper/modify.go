@@ -0,0 +1,269 @@
+package zipper
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EditStats describes how AddToZip, UpdateZip and DeleteFromZip changed an
+// archive.
+type EditStats struct {
+	Added    int // entries that were not in the archive before
+	Replaced int // entries written again with new contents
+	Deleted  int // entries removed
+	Kept     int // entries copied over unchanged
+	// TotalBytes is the size of the files read to add or replace entries.
+	TotalBytes int64
+	// Checksum is the SHA-256 checksum stored in the rewritten archive, or
+	// "" if nothing changed and the archive was left alone.
+	Checksum string
+}
+
+// AddToZip adds paths to the zip archive at zipPath, replacing entries of the
+// same name. Entries are named by their path relative to baseDir, so adding
+// "config/app.yml" from the project folder replaces that entry; directories
+// are added with everything inside them.
+func AddToZip(zipPath, baseDir string, paths []string, opts CreateOptions) (EditStats, error) {
+	files, err := collectRelative(zipPath, baseDir, paths, opts)
+	if err != nil {
+		return EditStats{}, err
+	}
+	return editZip(zipPath, files, opts, func(*zip.File, fileJob) bool {
+		return true
+	}, nil)
+}
+
+// UpdateZip brings the zip archive at zipPath up to date with srcDir, the
+// folder it was created from: files that are newer than their entry replace
+// it and files without an entry are added. Entries are named relative to
+// srcDir, the way ZipFiles names the contents of a single folder.
+func UpdateZip(zipPath, srcDir string, opts CreateOptions) (EditStats, error) {
+	files, err := collectRelative(zipPath, srcDir, []string{srcDir}, opts)
+	if err != nil {
+		return EditStats{}, err
+	}
+	return editZip(zipPath, files, opts, func(f *zip.File, job fileJob) bool {
+		return zipEntryOlder(f, job.info.ModTime())
+	}, nil)
+}
+
+// DeleteFromZip removes the entries of the zip archive at zipPath whose names
+// match any of patterns, using the same glob syntax as ExcludePolicy. When a
+// directory entry matches, everything inside it is removed too.
+func DeleteFromZip(zipPath string, patterns []string) (EditStats, error) {
+	compiled, err := compilePatterns(patterns)
+	if err != nil {
+		return EditStats{}, err
+	}
+	return editZip(zipPath, nil, CreateOptions{}, nil, func(f *zip.File) bool {
+		return matchWithin(compiled, strings.TrimSuffix(f.Name, "/"), f.FileInfo().IsDir())
+	})
+}
+
+// collectRelative collects the files below paths, naming each by its path
+// relative to baseDir.
+func collectRelative(zipPath, baseDir string, paths []string, opts CreateOptions) ([]fileJob, error) {
+	ex, err := opts.Exclude.compile()
+	if err != nil {
+		return nil, err
+	}
+	ex.skipOutputs(zipPath)
+
+	baseDir, err = filepath.Abs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []fileJob
+	collect := func(job fileJob) error {
+		files = append(files, job)
+		return nil
+	}
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(baseDir, abs)
+		if err != nil || !(rel == "." || filepath.IsLocal(rel)) {
+			return nil, fmt.Errorf("%s is outside %s", p, baseDir)
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return nil, err
+		}
+
+		if rel == "." {
+			rel = ""
+		} else if err := collect(fileJob{path: abs, rel: rel, info: info, isDir: info.IsDir()}); err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			if err := walkFiles(abs, rel, ex, opts.Symlinks, collect); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	opts.normalizer().sortFiles(files)
+	if opts.DedupHardLinks {
+		markHardLinks(files, true)
+	}
+	return files, nil
+}
+
+// zipEntryOlder reports whether the zip entry f is older than modTime. Without
+// an extended timestamp entries only keep MS-DOS time, which has a resolution
+// of two seconds.
+func zipEntryOlder(f *zip.File, modTime time.Time) bool {
+	resolution := time.Second
+	if !hasZipExtra(f.Extra, 0x5455) {
+		resolution = 2 * time.Second
+	}
+	return zipModTime(f).Truncate(resolution).Before(modTime.Truncate(resolution))
+}
+
+// editZip rewrites the zip archive at zipPath. Each of files whose entry does
+// not exist yet is added; where one exists, replace decides whether the file
+// replaces it. Entries that remove reports true for are left out, and every
+// other entry is copied over as raw compressed bytes, without recompressing.
+//
+// The new archive is written to a temporary file next to zipPath with a fresh
+// checksum and then renamed over it, so the archive is never left half
+// written. If nothing changes, the archive is not rewritten.
+func editZip(zipPath string, files []fileJob, opts CreateOptions, replace func(f *zip.File, job fileJob) bool, remove func(f *zip.File) bool) (stats EditStats, err error) {
+	format, err := DetectFormat(zipPath)
+	if err != nil {
+		return stats, err
+	}
+	if format != FormatZip {
+		return stats, fmt.Errorf("only zip archives can be modified, not %s", format)
+	}
+
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return stats, err
+	}
+	defer reader.Close()
+
+	existing := make(map[string]*zip.File, len(reader.File))
+	for _, f := range reader.File {
+		existing[f.Name] = f
+	}
+
+	// Decide which files go in and which entries they replace
+	replaced := make(map[string]bool)
+	seen := make(map[string]bool)
+	var write []fileJob
+	for _, job := range files {
+		name := filepath.ToSlash(job.rel)
+		if job.isDir {
+			name += "/"
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		f, ok := existing[name]
+		switch {
+		case !ok:
+			stats.Added++
+		case job.isDir:
+			// The directory entry is already there
+			continue
+		case !replace(f, job):
+			continue
+		default:
+			replaced[name] = true
+			stats.Replaced++
+		}
+		write = append(write, job)
+	}
+
+	var keep []*zip.File
+	for _, f := range reader.File {
+		switch {
+		case replaced[f.Name]:
+		case remove != nil && remove(f):
+			stats.Deleted++
+		default:
+			keep = append(keep, f)
+		}
+	}
+	stats.Kept = len(keep)
+	stats.TotalBytes = totalFiles(write).TotalBytes
+	if len(write) == 0 && stats.Deleted == 0 {
+		return stats, nil
+	}
+
+	info, err := os.Stat(zipPath)
+	if err != nil {
+		return stats, err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(zipPath), "."+filepath.Base(zipPath)+".*.tmp")
+	if err != nil {
+		return stats, err
+	}
+	tempPath := tempFile.Name()
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	// Hash the archive as it is written; the checksum goes into the comment
+	hash := sha256.New()
+	writer := zip.NewWriter(io.MultiWriter(tempFile, hash))
+	for _, f := range keep {
+		if err := copyRawEntry(writer, f); err != nil {
+			return stats, fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	if err := writeZipFiles(writer, write, stats.TotalBytes, filepath.Dir(zipPath), opts); err != nil {
+		return stats, err
+	}
+	if err := writer.Close(); err != nil {
+		return stats, err
+	}
+	stats.Checksum, err = appendZipChecksum(tempFile, hash.Sum(nil))
+	if err != nil {
+		return stats, fmt.Errorf("failed to add checksum: %w", err)
+	}
+	if err := tempFile.Chmod(info.Mode().Perm()); err != nil {
+		return stats, err
+	}
+	if err := tempFile.Close(); err != nil {
+		return stats, err
+	}
+
+	// Windows cannot replace a file that is still open
+	reader.Close()
+	if err := os.Rename(tempPath, zipPath); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// copyRawEntry copies the zip entry f to w as it is stored, without
+// decompressing and recompressing it.
+func copyRawEntry(w *zip.Writer, f *zip.File) error {
+	r, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	// CreateRaw modifies the header it is given
+	header := f.FileHeader
+	entry, err := w.CreateRaw(&header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, r)
+	return err
+}
@@ -0,0 +1,221 @@
+package zipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// editTree is the folder the archives in these tests were made from.
+var editTree = map[string]string{
+	"a.txt":          "hello\n",
+	"docs/guide.txt": string(testData(64 << 10)),
+	"img/b.jpg":      strings.Repeat("jpeg", 500),
+}
+
+// writeEditArchive writes src to a checksummed zip at path, compressing with
+// BestSpeed so that an entry compressed again by pz would come out different.
+// Files and entries all get the modification time mtime.
+func writeEditArchive(t *testing.T, src, path string, mtime time.Time) string {
+	t.Helper()
+	writeTree(t, src, editTree)
+	for _, name := range []string{"a.txt", "docs/guide.txt", "img/b.jpg", "docs", "img"} {
+		if err := os.Chtimes(filepath.Join(src, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	writer := zip.NewWriter(io.MultiWriter(f, hash))
+	writer.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.BestSpeed)
+	})
+	for _, name := range []string{"a.txt", "docs/", "docs/guide.txt", "img/", "img/b.jpg"} {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime}
+		if strings.HasSuffix(name, "/") {
+			header.Method = zip.Store
+		}
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, editTree[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := appendZipChecksum(f, hash.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checksum
+}
+
+// rawEntries returns the compressed bytes of each entry in the zip at path.
+func rawEntries(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	entries := make(map[string][]byte, len(r.File))
+	for _, f := range r.File {
+		raw, err := f.OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name] = data
+	}
+	return entries
+}
+
+func TestEditZip(t *testing.T) {
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		edit func(zipPath, src string) (EditStats, error)
+		want EditStats
+		// entries lists the entries after the edit; those in changed were
+		// written again and the rest must be copied byte for byte
+		entries []string
+		changed []string
+	}{
+		{
+			name: "add",
+			edit: func(zipPath, src string) (EditStats, error) {
+				writeTree(t, src, map[string]string{"new.txt": "new\n", "a.txt": "changed\n"})
+				paths := []string{filepath.Join(src, "new.txt"), filepath.Join(src, "a.txt")}
+				return AddToZip(zipPath, src, paths, CreateOptions{})
+			},
+			want:    EditStats{Added: 1, Replaced: 1, Kept: 4},
+			entries: []string{"a.txt", "docs/", "docs/guide.txt", "img/", "img/b.jpg", "new.txt"},
+			changed: []string{"a.txt", "new.txt"},
+		},
+		{
+			name: "update",
+			edit: func(zipPath, src string) (EditStats, error) {
+				writeTree(t, src, map[string]string{"a.txt": "changed\n"})
+				return UpdateZip(zipPath, src, CreateOptions{})
+			},
+			want:    EditStats{Replaced: 1, Kept: 4},
+			entries: []string{"a.txt", "docs/", "docs/guide.txt", "img/", "img/b.jpg"},
+			changed: []string{"a.txt"},
+		},
+		{
+			name: "delete",
+			edit: func(zipPath, src string) (EditStats, error) {
+				return DeleteFromZip(zipPath, []string{"docs"})
+			},
+			want:    EditStats{Deleted: 2, Kept: 3},
+			entries: []string{"a.txt", "img/", "img/b.jpg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "project")
+			outDir := t.TempDir()
+			zipPath := filepath.Join(outDir, "project.zip")
+			oldChecksum := writeEditArchive(t, src, zipPath, mtime)
+			if err := os.Chmod(zipPath, 0600); err != nil {
+				t.Fatal(err)
+			}
+			before := rawEntries(t, zipPath)
+			oldData, err := os.ReadFile(zipPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A reader that opened the archive before the edit keeps the old one
+			old, err := os.Open(zipPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer old.Close()
+
+			stats, err := tt.edit(zipPath, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := stats
+			got.TotalBytes, got.Checksum = 0, ""
+			if got != tt.want {
+				t.Errorf("stats = %+v, want %+v", got, tt.want)
+			}
+
+			// Unchanged entries are copied as they were stored
+			after := rawEntries(t, zipPath)
+			var names []string
+			for name := range after {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.entries) {
+				t.Errorf("entries = %q, want %q", names, tt.entries)
+			}
+			for name, data := range after {
+				if !slices.Contains(tt.changed, name) && !bytes.Equal(data, before[name]) {
+					t.Errorf("%s was not copied raw", name)
+				}
+			}
+
+			// The checksum is refreshed
+			ok, stored, err := VerifyChecksum(zipPath)
+			if err != nil || !ok || stored != stats.Checksum {
+				t.Errorf("VerifyChecksum = %v, %q, %v; want true, %q", ok, stored, err, stats.Checksum)
+			}
+			if stats.Checksum == oldChecksum {
+				t.Error("checksum did not change")
+			}
+
+			// The archive was replaced in one step, keeping its mode
+			if got, err := io.ReadAll(old); err != nil || !bytes.Equal(got, oldData) {
+				t.Errorf("old archive changed under an open reader: %v", err)
+			}
+			if got := listTree(t, outDir); !reflect.DeepEqual(got, []string{"project.zip"}) {
+				t.Errorf("%s holds %q", outDir, got)
+			}
+			if info, err := os.Stat(zipPath); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("archive mode = %v, %v; want 0600", info.Mode().Perm(), err)
+			}
+		})
+	}
+}
+
+func TestUpdateZipUpToDate(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "project")
+	zipPath := filepath.Join(t.TempDir(), "project.zip")
+	writeEditArchive(t, src, zipPath, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	want := fileHash(t, zipPath)
+
+	stats, err := UpdateZip(zipPath, src, CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Checksum != "" || stats.Kept != 5 || stats.Added+stats.Replaced+stats.Deleted != 0 {
+		t.Errorf("stats = %+v, want 5 kept and nothing else", stats)
+	}
+	if fileHash(t, zipPath) != want {
+		t.Error("archive rewritten with nothing to change")
+	}
+}
@@ -4,43 +4,74 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// archiveExtensions lists the multi-part extensions recognised by
+// SplitArchiveExt, longest first.
+var archiveExtensions = []string{".tar.zst", ".tar.gz", ".tgz", ".tar", ".zip"}
+
 // NextArchiveName determines a unique zip filename for baseName within dir.
 func NextArchiveName(dir, baseName string) (string, error) {
-	if dir == "" {
-		dir = "."
-	}
+	return nextName(dir, baseName, ".zip")
+}
 
-	tryName := func(version int) string {
-		if version == 0 {
-			return filepath.Join(dir, fmt.Sprintf("%s.zip", baseName))
-		}
-		return filepath.Join(dir, fmt.Sprintf("%s-v%d.zip", baseName, version))
-	}
+// NextGzipArchiveName determines a unique tar.gz filename for baseName within dir.
+func NextGzipArchiveName(dir, baseName string) (string, error) {
+	return nextName(dir, baseName, ".tar.gz")
+}
 
-	for version := 0; ; version++ {
-		candidate := tryName(version)
-		if _, err := os.Stat(candidate); err != nil {
-			if os.IsNotExist(err) {
-				return candidate, nil
-			}
-			return "", err
+// NextTarArchiveName determines a unique tar filename for baseName within dir.
+func NextTarArchiveName(dir, baseName string) (string, error) {
+	return nextName(dir, baseName, ".tar")
+}
+
+// NextZstdArchiveName determines a unique tar.zst filename for baseName within dir.
+func NextZstdArchiveName(dir, baseName string) (string, error) {
+	return nextName(dir, baseName, ".tar.zst")
+}
+
+// NextGzipFileName determines a unique name for the gzip stream of the single
+// file fileName within dir, such as "access.log.gz".
+func NextGzipFileName(dir, fileName string) (string, error) {
+	return nextName(dir, fileName, ".gz")
+}
+
+// NextAvailableName returns path if nothing exists there yet, or otherwise
+// the first free versioned name next to it, such as "out-v1.zip".
+func NextAvailableName(path string) (string, error) {
+	base, ext := SplitArchiveExt(filepath.Base(path))
+	return nextName(filepath.Dir(path), base, ext)
+}
+
+// SplitArchiveExt splits name into its base and archive extension, treating
+// compound extensions such as ".tar.gz" as one.
+func SplitArchiveExt(name string) (base, ext string) {
+	lower := strings.ToLower(name)
+	for _, e := range archiveExtensions {
+		if strings.HasSuffix(lower, e) && len(name) > len(e) {
+			return name[:len(name)-len(e)], name[len(name)-len(e):]
 		}
 	}
+	ext = filepath.Ext(name)
+	if ext == name {
+		return name, ""
+	}
+	return strings.TrimSuffix(name, ext), ext
 }
 
-// NextGzipArchiveName determines a unique tar.gz filename for baseName within dir.
-func NextGzipArchiveName(dir, baseName string) (string, error) {
+// nextName returns dir/baseName+ext, or the first of dir/baseName-v1+ext,
+// dir/baseName-v2+ext, ... that does not exist yet.
+func nextName(dir, baseName, ext string) (string, error) {
 	if dir == "" {
 		dir = "."
 	}
 
 	tryName := func(version int) string {
 		if version == 0 {
-			return filepath.Join(dir, fmt.Sprintf("%s.tar.gz", baseName))
+			return filepath.Join(dir, fmt.Sprintf("%s%s", baseName, ext))
 		}
-		return filepath.Join(dir, fmt.Sprintf("%s-v%d.tar.gz", baseName, version))
+		return filepath.Join(dir, fmt.Sprintf("%s-v%d%s", baseName, version, ext))
 	}
 
 	for version := 0; ; version++ {
@@ -0,0 +1,249 @@
+package zipper
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// gzipBlockSize is the amount of input compressed independently by each goroutine.
+	gzipBlockSize = 1 << 20
+	// gzipWindowSize is the DEFLATE window; each block is primed with this
+	// much of the preceding input so back-references span block boundaries.
+	gzipWindowSize = 32 << 10
+)
+
+// gzipBlock is one slice of the input on its way through a compressor goroutine.
+type gzipBlock struct {
+	data []byte
+	dict []byte
+	last bool
+	out  bytes.Buffer
+	err  error
+	done chan struct{}
+}
+
+// parallelGzipWriter compresses independent blocks on several goroutines and
+// concatenates them into a single standard gzip member, the same way pigz
+// does. Every block except the last ends with a sync flush so it finishes on
+// a byte boundary, and every block after the first is primed with the last
+// 32 KB of the block before it, so the result decompresses with any gunzip.
+type parallelGzipWriter struct {
+	// Name and ModTime are written to the gzip header if set before the
+	// first call to Write or Close.
+	Name    string
+	ModTime time.Time
+
+	w       io.Writer
+	level   int
+	buf     []byte
+	prev    []byte
+	crc     uint32
+	size    uint32
+	started bool
+	closed  bool
+
+	sem     chan struct{}
+	queue   chan *gzipBlock
+	written chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// newParallelGzipWriter returns a gzip writer that compresses at level using
+// up to workers goroutines.
+func newParallelGzipWriter(w io.Writer, level, workers int) (*parallelGzipWriter, error) {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, errors.New("gzip: invalid compression level")
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return &parallelGzipWriter{
+		w:     w,
+		level: level,
+		sem:   make(chan struct{}, workers),
+		queue: make(chan *gzipBlock, workers),
+	}, nil
+}
+
+func (z *parallelGzipWriter) setErr(err error) {
+	z.mu.Lock()
+	if z.err == nil {
+		z.err = err
+	}
+	z.mu.Unlock()
+}
+
+func (z *parallelGzipWriter) getErr() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.err
+}
+
+// start writes the gzip header and launches the goroutine that emits
+// compressed blocks in order.
+func (z *parallelGzipWriter) start() error {
+	z.started = true
+
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	if z.ModTime.After(time.Unix(0, 0)) {
+		binary.LittleEndian.PutUint32(header[4:8], uint32(z.ModTime.Unix()))
+	}
+	switch z.level {
+	case flate.BestCompression:
+		header[8] = 2
+	case flate.BestSpeed, flate.HuffmanOnly:
+		header[8] = 4
+	}
+	if z.Name != "" {
+		name, err := latin1(z.Name)
+		if err != nil {
+			return err
+		}
+		header[3] |= 0x08 // FNAME
+		header = append(header, name...)
+		header = append(header, 0)
+	}
+	if _, err := z.w.Write(header); err != nil {
+		return err
+	}
+
+	z.written = make(chan struct{})
+	go func() {
+		defer close(z.written)
+		for b := range z.queue {
+			<-b.done
+			if z.getErr() != nil {
+				continue
+			}
+			if b.err != nil {
+				z.setErr(b.err)
+				continue
+			}
+			if _, err := z.w.Write(b.out.Bytes()); err != nil {
+				z.setErr(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// latin1 converts s to ISO 8859-1 as required by the gzip header.
+func latin1(s string) ([]byte, error) {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r == 0 || r > 0xff {
+			return nil, errors.New("gzip: non-Latin-1 header string")
+		}
+		out = append(out, byte(r))
+	}
+	return out, nil
+}
+
+// Write buffers p and hands off every full block to a compressor goroutine.
+func (z *parallelGzipWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("gzip: write after close")
+	}
+	if err := z.getErr(); err != nil {
+		return 0, err
+	}
+	if !z.started {
+		if err := z.start(); err != nil {
+			z.setErr(err)
+			return 0, err
+		}
+	}
+
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+
+	n := len(p)
+	for len(p) > 0 {
+		if z.buf == nil {
+			z.buf = make([]byte, 0, gzipBlockSize)
+		}
+		chunk := min(len(p), gzipBlockSize-len(z.buf))
+		z.buf = append(z.buf, p[:chunk]...)
+		p = p[chunk:]
+		if len(z.buf) == gzipBlockSize {
+			z.dispatch(false)
+		}
+	}
+	return n, z.getErr()
+}
+
+// dispatch sends the buffered block to a compressor goroutine. The queue is
+// bounded, so at most a few blocks per worker are held in memory.
+func (z *parallelGzipWriter) dispatch(last bool) {
+	b := &gzipBlock{data: z.buf, last: last, done: make(chan struct{})}
+	if len(z.prev) > 0 {
+		b.dict = z.prev[max(0, len(z.prev)-gzipWindowSize):]
+	}
+	z.prev = z.buf
+	z.buf = nil
+
+	z.queue <- b
+	z.sem <- struct{}{}
+	go func() {
+		defer func() {
+			<-z.sem
+			close(b.done)
+		}()
+		b.err = compressBlock(&b.out, b.data, b.dict, z.level, b.last)
+	}()
+}
+
+func compressBlock(out *bytes.Buffer, data, dict []byte, level int, last bool) error {
+	out.Grow(len(data) / 2)
+	fw, err := flate.NewWriterDict(out, level, dict)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	if last {
+		return fw.Close()
+	}
+	return fw.Flush()
+}
+
+// Close compresses the remaining input, waits for every block to be written
+// and finishes the stream with the gzip trailer. It does not close the
+// underlying writer.
+func (z *parallelGzipWriter) Close() error {
+	if z.closed {
+		return z.getErr()
+	}
+	if !z.started {
+		if err := z.start(); err != nil {
+			z.setErr(err)
+		}
+	}
+	z.closed = true
+	if z.written == nil {
+		return z.getErr()
+	}
+
+	z.dispatch(true)
+	close(z.queue)
+	<-z.written
+	if err := z.getErr(); err != nil {
+		return err
+	}
+
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[0:4], z.crc)
+	binary.LittleEndian.PutUint32(trailer[4:8], z.size)
+	_, err := z.w.Write(trailer[:])
+	return err
+}
@@ -0,0 +1,140 @@
+package zipper
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// testData returns size bytes of text-like data that compresses well and has
+// matches reaching back across block boundaries.
+func testData(size int) []byte {
+	words := []string{"archive", "block", "deflate", "window", "stream", "parallel", "gzip", "zipper", "\n"}
+	rng := rand.New(rand.NewSource(int64(size)))
+	var b bytes.Buffer
+	for b.Len() < size {
+		b.WriteString(words[rng.Intn(len(words))])
+		b.WriteByte(' ')
+	}
+	return b.Bytes()[:size]
+}
+
+// compressParallel compresses data with a parallelGzipWriter, writing it in
+// uneven pieces so blocks are cut in the middle of writes.
+func compressParallel(t *testing.T, data []byte, level, workers int) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	z, err := newParallelGzipWriter(&out, level, workers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := data; len(p) > 0; {
+		n := min(len(p), 100_003)
+		if _, err := z.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestParallelGzipRoundTrip(t *testing.T) {
+	sizes := []int{0, 1, gzipBlockSize - 1, gzipBlockSize, gzipBlockSize + 1, 3*gzipBlockSize + 12345}
+	levels := []int{flate.HuffmanOnly, flate.DefaultCompression, flate.BestSpeed, flate.BestCompression}
+	for _, size := range sizes {
+		data := testData(size)
+		for _, level := range levels {
+			t.Run(fmt.Sprintf("size=%d/level=%d", size, level), func(t *testing.T) {
+				compressed := compressParallel(t, data, level, 4)
+
+				r, err := gzip.NewReader(bytes.NewReader(compressed))
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.Multistream(false)
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(data))
+				}
+			})
+		}
+	}
+}
+
+func TestParallelGzipSingleMember(t *testing.T) {
+	data := testData(2*gzipBlockSize + 7)
+	compressed := compressParallel(t, data, flate.DefaultCompression, 4)
+
+	// With multistream off, everything must be in the first member
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Multistream(false)
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("first member holds %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestParallelGzipWorkerCountIndependent(t *testing.T) {
+	data := testData(4*gzipBlockSize + 999)
+	for _, level := range []int{flate.HuffmanOnly, flate.DefaultCompression, flate.BestSpeed, flate.BestCompression} {
+		one := compressParallel(t, data, level, 1)
+		for _, workers := range []int{2, 8} {
+			if many := compressParallel(t, data, level, workers); !bytes.Equal(one, many) {
+				t.Errorf("level %d: output with %d workers differs from 1 worker", level, workers)
+			}
+		}
+	}
+}
+
+func TestParallelGzipHeader(t *testing.T) {
+	modTime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	var out bytes.Buffer
+	z, err := newParallelGzipWriter(&out, flate.BestCompression, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.Name = "report.txt"
+	z.ModTime = modTime
+	if _, err := z.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "report.txt" || !r.ModTime.Equal(modTime) {
+		t.Errorf("header = %q %v, want %q %v", r.Name, r.ModTime, "report.txt", modTime)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "hello" {
+		t.Errorf("content = %q, %v", got, err)
+	}
+}
+
+func TestParallelGzipInvalidLevel(t *testing.T) {
+	for _, level := range []int{-3, 10} {
+		if _, err := newParallelGzipWriter(io.Discard, level, 1); err == nil {
+			t.Errorf("level %d: expected an error", level)
+		}
+	}
+}
@@ -0,0 +1,567 @@
+package zipper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMemoryLimit is the read-ahead budget used when CreateOptions.MemoryLimit is zero.
+const DefaultMemoryLimit = 256 << 20
+
+// maxReadAhead is the largest file that is ever read into memory in one piece.
+const maxReadAhead = 16 << 20
+
+// streamBufferSize is the chunk size used when streaming large files.
+const streamBufferSize = 1 << 20
+
+// CreateOptions controls how archives are created. The zero value uses the defaults.
+type CreateOptions struct {
+	// MemoryLimit caps the number of bytes of file data held in memory at once.
+	// Small files are read ahead in parallel within this budget; larger files
+	// are streamed in chunks. Zero means DefaultMemoryLimit.
+	MemoryLimit int64
+
+	// Exclude selects the files and directories that are left out.
+	Exclude ExcludePolicy
+
+	// Symlinks decides how symbolic links inside the sources are archived.
+	// The zero value stores them as links.
+	Symlinks SymlinkPolicy
+
+	// DedupHardLinks stores files that are hard links to a file already in
+	// a zip archive as symlinks to that first copy. Zip has no hard links,
+	// so by default every copy is stored in full. tar.gz archives always
+	// store repeats as hard link entries.
+	DedupHardLinks bool
+
+	// Xattrs stores the extended attributes of every entry, including POSIX
+	// ACLs, in PAX records of tar archives. Only supported on Linux.
+	Xattrs bool
+
+	// Reproducible makes the archive depend only on the file names and
+	// contents: entries are sorted by path, every timestamp is SourceDate and
+	// ownership and permissions are normalized.
+	Reproducible bool
+
+	// SourceDate is the timestamp of every entry in a reproducible archive.
+	// Zero means DefaultSourceDate.
+	SourceDate time.Time
+
+	// Progress, if set, receives progress updates with the current file.
+	Progress ProgressWithFileFunc
+}
+
+func (o CreateOptions) memoryLimit() int64 {
+	if o.MemoryLimit <= 0 {
+		return DefaultMemoryLimit
+	}
+	return o.MemoryLimit
+}
+
+// memoryBudget is a counting semaphore measured in bytes.
+type memoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	avail int64
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	b := &memoryBudget{avail: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until n bytes of the budget are available and reserves them.
+func (b *memoryBudget) acquire(n int64) {
+	b.mu.Lock()
+	for b.avail < n {
+		b.cond.Wait()
+	}
+	b.avail -= n
+	b.mu.Unlock()
+}
+
+// release returns n bytes to the budget.
+func (b *memoryBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.avail += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// readAheadLimit returns the largest file size that workers read fully into
+// memory. Every worker can hold one such file while the writer drains another.
+func readAheadLimit(budget int64, workers int) int64 {
+	limit := budget / int64(2*workers+1)
+	if limit > maxReadAhead {
+		limit = maxReadAhead
+	}
+	return limit
+}
+
+// fileData carries a file from the readers to the sequential archive writer.
+// Files above the read-ahead limit arrive with stream set and no data; the
+// writer copies them from disk in chunks instead, unless a processor already
+// turned them into a spool file.
+type fileData struct {
+	job      fileJob
+	seq      int
+	data     []byte
+	stream   bool
+	skip     bool
+	reserved int64
+	err      error
+
+	// Set by processors that pre-compress entries for raw writing.
+	raw    bool
+	method uint16
+	crc    uint32
+	size   int64
+	spool  *os.File
+}
+
+// release returns the memory held by fd and removes its spool file, if any.
+func (fd *fileData) release(budget *memoryBudget) {
+	budget.release(fd.reserved)
+	fd.reserved = 0
+	fd.data = nil
+	if fd.spool != nil {
+		fd.spool.Close()
+		os.Remove(fd.spool.Name())
+		fd.spool = nil
+	}
+}
+
+// errSkipFile is returned by a fileProcessor to drop a file it could not read.
+var errSkipFile = errors.New("skip file")
+
+// fileProcessor runs on a reader worker for every regular file before it is
+// handed to the writer. It may replace fd.data or set the raw fields.
+type fileProcessor func(fd *fileData) error
+
+// walkSources calls fn for every entry of sources that ex keeps. A lone
+// directory contributes its contents; every other source is stored under its
+// base name, with directories expanded below it. Sources named explicitly
+// are never excluded themselves, and links among them are always followed.
+func walkSources(sources []string, ex *excluder, links SymlinkPolicy, fn func(job fileJob) error) error {
+	if len(sources) == 0 {
+		return errors.New("no sources to archive")
+	}
+
+	if len(sources) == 1 {
+		info, err := os.Stat(sources[0])
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return walkFiles(sources[0], "", ex, links, fn)
+		}
+	}
+
+	seen := make(map[string]string)
+	for _, src := range sources {
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Base(src)
+		if other, ok := seen[strings.ToLower(name)]; ok {
+			return fmt.Errorf("%s and %s would both be stored as %s", other, src, name)
+		}
+		seen[strings.ToLower(name)] = src
+
+		if err := fn(fileJob{path: src, rel: name, info: info, isDir: info.IsDir()}); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err := walkFiles(src, name, ex, links, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// treeWalker walks one source directory.
+type treeWalker struct {
+	ex     *excluder
+	filter *walkFilter
+	links  SymlinkPolicy
+	prefix string
+	fn     func(job fileJob) error
+
+	// walking holds the real paths of the directories being walked, so
+	// followed links that lead back into one of them can be skipped.
+	walking map[string]bool
+}
+
+// walkFiles calls fn for every entry below srcDir that ex keeps, naming each
+// entry prefix/rel. Excluded directories are not descended into.
+func walkFiles(srcDir, prefix string, ex *excluder, links SymlinkPolicy, fn func(job fileJob) error) error {
+	filter, err := ex.forTree(srcDir)
+	if err != nil {
+		return err
+	}
+	if err := filter.enterDir(srcDir, "."); err != nil {
+		return err
+	}
+
+	w := &treeWalker{ex: ex, filter: filter, links: links, prefix: prefix, fn: fn, walking: make(map[string]bool)}
+	return w.walk(srcDir, "")
+}
+
+// walk visits the contents of the directory dir, whose path relative to the
+// source root is base.
+func (w *treeWalker) walk(dir, base string) error {
+	if w.links == SymlinksFollow {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		w.walking[real] = true
+		defer delete(w.walking, real)
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if path == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.Join(base, rel)
+
+		isLink := d.Type()&fs.ModeSymlink != 0
+		if isLink && w.links == SymlinksSkip {
+			return nil
+		}
+
+		if isLink && w.links == SymlinksFollow {
+			return w.follow(path, rel)
+		}
+
+		if w.ex.isOutput(path) || w.filter.skip(filepath.ToSlash(rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			if err := w.filter.enterDir(path, rel); err != nil {
+				return err
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var target string
+		if isLink {
+			if target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		return w.fn(fileJob{
+			path:  path,
+			rel:   filepath.Join(w.prefix, rel),
+			info:  info,
+			isDir: d.IsDir(),
+			link:  target,
+		})
+	})
+}
+
+// follow archives what the link at path points to under the link's own name.
+func (w *treeWalker) follow(path, rel string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: skipping broken symlink %s: %v\n", path, err)
+		return nil
+	}
+
+	if w.ex.isOutput(path) || w.filter.skip(filepath.ToSlash(rel), info.IsDir()) {
+		return nil
+	}
+
+	job := fileJob{path: path, rel: filepath.Join(w.prefix, rel), info: info, isDir: info.IsDir()}
+	if !info.IsDir() {
+		return w.fn(job)
+	}
+
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	if w.walking[real] {
+		fmt.Fprintf(os.Stderr, "Warning: skipping symlink loop %s\n", path)
+		return nil
+	}
+	if err := w.filter.enterDir(path, rel); err != nil {
+		return err
+	}
+	if err := w.fn(job); err != nil {
+		return err
+	}
+	// WalkDir does not descend into a link, so walk its target instead
+	return w.walk(real, rel)
+}
+
+// collectFiles returns every entry of sources that ex keeps.
+func collectFiles(sources []string, ex *excluder, links SymlinkPolicy) ([]fileJob, error) {
+	var files []fileJob
+	err := walkSources(sources, ex, links, func(job fileJob) error {
+		files = append(files, job)
+		return nil
+	})
+	return files, err
+}
+
+// readWindowPerWorker bounds how many files per worker may be in flight
+// between the walk and the writer. It keeps the reorder buffer, and the
+// spool files waiting in it, small while a slow entry holds up the writer.
+const readWindowPerWorker = 4
+
+// readPipeline reads small files ahead on a worker pool while holding at most
+// the memory budget of file data, and delivers them to the writer in walk
+// order no matter which worker finishes first.
+type readPipeline struct {
+	out    <-chan fileData
+	budget *memoryBudget
+	slots  chan struct{}
+	stop   chan struct{}
+}
+
+// readFiles starts a readPipeline over files. Files no larger than limit are
+// read into memory; larger ones are left for process or the writer to stream.
+// The writer must call done for every fileData it receives and close once it
+// has finished or given up.
+//
+// If process is set, every read-ahead file reserves twice its size so the
+// processor has room for its output; whatever the result does not need is
+// returned to the budget afterwards.
+func readFiles(files []fileJob, memoryLimit, limit int64, process fileProcessor) *readPipeline {
+	workerCount := getWorkerCount()
+	p := &readPipeline{
+		budget: newMemoryBudget(memoryLimit),
+		slots:  make(chan struct{}, workerCount*readWindowPerWorker),
+		stop:   make(chan struct{}),
+	}
+	dataChan := make(chan fileData, workerCount)
+	jobChan := make(chan fileData, workerCount)
+	var wg sync.WaitGroup
+
+	// Reserve memory in walk order so a large file can never be starved by
+	// smaller ones queued behind it.
+	go func() {
+		defer close(jobChan)
+		for i, job := range files {
+			select {
+			case <-p.stop:
+				return
+			case p.slots <- struct{}{}:
+			}
+
+			fd := fileData{job: job, seq: i}
+			if job.isRegular() {
+				if size := job.info.Size(); size > limit {
+					fd.stream = true
+				} else {
+					fd.reserved = size
+					if process != nil {
+						fd.reserved *= 2
+					}
+					p.budget.acquire(fd.reserved)
+				}
+			}
+			jobChan <- fd
+		}
+	}()
+
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fd := range jobChan {
+				p.read(&fd, process)
+				dataChan <- fd
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(dataChan)
+	}()
+
+	p.out = p.reorder(dataChan)
+	return p
+}
+
+// read loads and processes one file on a worker. Files that cannot be read
+// are marked skip.
+func (p *readPipeline) read(fd *fileData, process fileProcessor) {
+	select {
+	case <-p.stop:
+		fd.skip = true
+		return
+	default:
+	}
+
+	if !fd.job.isRegular() {
+		return
+	}
+
+	if !fd.stream {
+		data, err := os.ReadFile(fd.job.path)
+		if err != nil {
+			// Skip inaccessible files instead of failing
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", fd.job.path, err)
+			fd.skip = true
+			return
+		}
+		fd.data = data
+	}
+
+	if process != nil {
+		if err := process(fd); errors.Is(err, errSkipFile) {
+			fd.skip = true
+			return
+		} else if err != nil {
+			fd.err = fmt.Errorf("%s: %w", fd.job.rel, err)
+		}
+		if held := int64(len(fd.data)); held < fd.reserved {
+			p.budget.release(fd.reserved - held)
+			fd.reserved = held
+		}
+	}
+}
+
+// reorder forwards the items of in sorted by seq and drops skipped ones.
+func (p *readPipeline) reorder(in <-chan fileData) <-chan fileData {
+	out := make(chan fileData)
+	go func() {
+		defer close(out)
+		pending := make(map[int]fileData)
+		next := 0
+		for fd := range in {
+			pending[fd.seq] = fd
+			for {
+				ready, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				if ready.skip {
+					p.done(&ready)
+					continue
+				}
+				select {
+				case out <- ready:
+				case <-p.stop:
+					p.done(&ready)
+				}
+			}
+		}
+		// Only left over when the pipeline was stopped early
+		for _, fd := range pending {
+			p.done(&fd)
+		}
+	}()
+	return out
+}
+
+// done releases everything fd holds once the writer is finished with it.
+func (p *readPipeline) done(fd *fileData) {
+	fd.release(p.budget)
+	<-p.slots
+}
+
+// close abandons the pipeline, releasing any pending items so the reader
+// goroutines can exit.
+func (p *readPipeline) close() {
+	select {
+	case <-p.stop:
+		return
+	default:
+	}
+	close(p.stop)
+	for fd := range p.out {
+		p.done(&fd)
+	}
+}
+
+// openStream opens a file that is too large to read ahead. Inaccessible
+// files are reported and skipped, matching the read-ahead workers.
+func openStream(job fileJob) (*os.File, bool) {
+	f, err := os.Open(job.path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", job.path, err)
+		return nil, false
+	}
+	return f, true
+}
+
+// streamFile copies r into w in fixed-size chunks, calling onWrite after
+// every chunk. It returns the number of bytes copied.
+func streamFile(w io.Writer, r io.Reader, onWrite func(n int64)) (int64, error) {
+	buf := make([]byte, streamBufferSize)
+	var written int64
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			onWrite(int64(n))
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
+
+// limitedWriter passes through at most n bytes and silently drops the rest.
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	if int64(len(p)) > lw.n {
+		p = p[:lw.n]
+	}
+	if len(p) > 0 {
+		n, err := lw.w.Write(p)
+		lw.n -= int64(n)
+		if err != nil {
+			return n, err
+		}
+	}
+	return total, nil
+}
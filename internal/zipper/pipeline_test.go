@@ -0,0 +1,44 @@
+package zipper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFilesWrappedSkip(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "b.txt": "b"})
+	var files []fileJob
+	for _, name := range []string{"a.txt", "b.txt"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fileJob{path: filepath.Join(dir, name), rel: name, info: info})
+	}
+
+	// A processor may wrap errSkipFile with the reason the file was dropped
+	process := func(fd *fileData) error {
+		if fd.job.rel == "a.txt" {
+			return fmt.Errorf("cannot open: %w", errSkipFile)
+		}
+		return nil
+	}
+	pipe := readFiles(files, 1<<20, 1<<20, process)
+	defer pipe.close()
+	var kept []string
+	for fd := range pipe.out {
+		if fd.err != nil {
+			t.Errorf("%s: %v", fd.job.rel, fd.err)
+		}
+		if !fd.skip {
+			kept = append(kept, fd.job.rel)
+		}
+		pipe.done(&fd)
+	}
+	if len(kept) != 1 || kept[0] != "b.txt" {
+		t.Errorf("kept %q, want only b.txt", kept)
+	}
+}
@@ -0,0 +1,81 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DefaultSourceDate is the timestamp given to every entry of a reproducible
+// archive when CreateOptions.SourceDate is not set. It is also the earliest
+// time used, since MS-DOS timestamps in zip files cannot go back further.
+var DefaultSourceDate = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// normalizer rewrites the metadata of archive entries so that the same
+// input always produces a byte-identical archive. A nil normalizer leaves
+// entries untouched.
+type normalizer struct {
+	modTime time.Time
+}
+
+// normalizer returns the normalizer for o, or nil unless o.Reproducible is set.
+func (o CreateOptions) normalizer() *normalizer {
+	if !o.Reproducible {
+		return nil
+	}
+	t := o.SourceDate
+	if t.Before(DefaultSourceDate) {
+		t = DefaultSourceDate
+	}
+	return &normalizer{modTime: t.UTC().Truncate(time.Second)}
+}
+
+// sortFiles puts files in path order, independent of the order the sources
+// were given in. Directories still come before their contents.
+func (n *normalizer) sortFiles(files []fileJob) {
+	if n == nil {
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return filepath.ToSlash(files[i].rel) < filepath.ToSlash(files[j].rel)
+	})
+}
+
+func (n *normalizer) zipHeader(h *zip.FileHeader) {
+	if n == nil {
+		return
+	}
+	h.Modified = n.modTime
+	h.SetMode(normalizedMode(h.Mode()))
+}
+
+func (n *normalizer) tarHeader(h *tar.Header) {
+	if n == nil {
+		return
+	}
+	h.ModTime = n.modTime
+	h.AccessTime = time.Time{}
+	h.ChangeTime = time.Time{}
+	h.Uid, h.Gid = 0, 0
+	h.Uname, h.Gname = "", ""
+	h.Mode = int64(normalizedMode(fs.FileMode(h.Mode)).Perm())
+}
+
+// normalizedMode reduces mode to 0755 for directories and executables and
+// 0644 for everything else, so the umask of the machine does not leak in.
+// Symlinks keep the conventional 0777.
+func normalizedMode(mode fs.FileMode) fs.FileMode {
+	switch {
+	case mode.IsDir():
+		return fs.ModeDir | 0755
+	case mode&fs.ModeSymlink != 0:
+		return fs.ModeSymlink | 0777
+	case mode&0111 != 0:
+		return 0755
+	default:
+		return 0644
+	}
+}
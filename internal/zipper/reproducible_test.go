@@ -0,0 +1,132 @@
+package zipper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withCPUs makes the package see n CPUs until the test ends.
+func withCPUs(t *testing.T, n int) {
+	t.Helper()
+	saved := numCPU
+	numCPU = func() int { return n }
+	t.Cleanup(func() { numCPU = saved })
+}
+
+// writeReproducibleTree creates files that take every path through the zip
+// writer: small and large, compressed and stored.
+func writeReproducibleTree(t *testing.T, dir string) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	random := func(n int) string {
+		b := make([]byte, n)
+		rng.Read(b)
+		return string(b)
+	}
+	writeTree(t, dir, map[string]string{
+		"README.md":         "hello\n",
+		"empty.txt":         "",
+		"docs/guide.txt":    string(testData(100 << 10)),
+		"docs/big.log":      string(testData(3 << 19)),
+		"img/small.jpg":     random(100 << 10),
+		"img/large.jpg":     random(3 << 19),
+		"bin/tool.sh":       "#!/bin/sh\necho hi\n",
+		"deep/a/b/c/d.json": `{"a": 1}`,
+	})
+	if err := os.Chmod(filepath.Join(dir, "bin/tool.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Times and modes that a reproducible archive must not depend on
+	for i, name := range []string{"README.md", "img/large.jpg", "docs"} {
+		mtime := time.Now().Add(-time.Duration(i+1) * time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func fileHash(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestReproducibleAcrossMachines(t *testing.T) {
+	src := t.TempDir()
+	writeReproducibleTree(t, src)
+
+	// Every file is read ahead with the most memory; with less memory and
+	// more workers, first the large and then the small files are streamed
+	setups := []struct {
+		memory int64
+		cpus   int
+	}{
+		{256 << 20, 1},
+		{256 << 20, 64},
+		{8 << 20, 8},
+		{1 << 20, 64},
+		{1 << 20, 1},
+	}
+	formats := []struct {
+		ext    string
+		create func(sources []string, path string, opts CreateOptions) (ArchiveStats, error)
+	}{
+		{".zip", ZipFiles},
+		{".tar.gz", GzipFiles},
+		{".tar.zst", ZstdFiles},
+		{".tar", TarFiles},
+	}
+	for _, format := range formats {
+		t.Run(format.ext, func(t *testing.T) {
+			out := t.TempDir()
+			want := ""
+			for i, setup := range setups {
+				withCPUs(t, setup.cpus)
+				path := filepath.Join(out, fmt.Sprintf("%d%s", i, format.ext))
+				opts := CreateOptions{Reproducible: true, MemoryLimit: setup.memory}
+				if _, err := format.create([]string{src}, path, opts); err != nil {
+					t.Fatal(err)
+				}
+				got := fileHash(t, path)
+				if i == 0 {
+					want = got
+				} else if got != want {
+					t.Errorf("memory %d, %d CPUs: SHA-256 %s, want %s", setup.memory, setup.cpus, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReproducibleIgnoresFileTimes(t *testing.T) {
+	src := t.TempDir()
+	writeReproducibleTree(t, src)
+	out := t.TempDir()
+
+	first := filepath.Join(out, "first.zip")
+	if _, err := ZipFiles([]string{src}, first, CreateOptions{Reproducible: true}); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "README.md"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(out, "second.zip")
+	if _, err := ZipFiles([]string{src}, second, CreateOptions{Reproducible: true}); err != nil {
+		t.Fatal(err)
+	}
+	if fileHash(t, first) != fileHash(t, second) {
+		t.Error("archive changed with the modification time of a source file")
+	}
+}
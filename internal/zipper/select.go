@@ -0,0 +1,88 @@
+package zipper
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// entrySelector decides which entries of an archive are extracted, from the
+// Include and Exclude patterns of ExtractOptions.
+type entrySelector struct {
+	include []pathPattern
+	exclude []pathPattern
+}
+
+func newEntrySelector(opts ExtractOptions) (*entrySelector, error) {
+	include, err := compilePatterns(opts.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compilePatterns(opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &entrySelector{include: include, exclude: exclude}, nil
+}
+
+// all reports whether every entry is extracted.
+func (s *entrySelector) all() bool {
+	return len(s.include) == 0 && len(s.exclude) == 0
+}
+
+// match reports whether the entry called name is extracted. Names are matched
+// without a leading "./", which tar archives often have.
+func (s *entrySelector) match(name string, isDir bool) bool {
+	if s.all() {
+		return true
+	}
+	rel := path.Clean(name)
+	if len(s.include) > 0 && !matchWithin(s.include, rel, isDir) {
+		return false
+	}
+	return !matchWithin(s.exclude, rel, isDir)
+}
+
+// RenameRule writes entries whose name starts with Old under New instead,
+// such as "project-1.2.3/" to "src/".
+type RenameRule struct {
+	Old string
+	New string
+}
+
+// ParseRenameRule parses a rule written as "old=new".
+func ParseRenameRule(s string) (RenameRule, error) {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok || from == "" {
+		return RenameRule{}, fmt.Errorf("invalid rename rule %q (use old=new)", s)
+	}
+	return RenameRule{Old: filepath.ToSlash(from), New: filepath.ToSlash(to)}, nil
+}
+
+// entryName returns the name the entry called name is extracted to, after
+// StripComponents and the first matching Rename rule, or false if nothing is
+// left of it. A leading "./" does not count as a component. The result still
+// has to pass the path traversal check.
+func (o ExtractOptions) entryName(name string) (string, bool) {
+	if o.StripComponents <= 0 && len(o.Rename) == 0 {
+		return name, true
+	}
+	for strings.HasPrefix(name, "./") {
+		name = name[2:]
+	}
+	for i := 0; i < o.StripComponents; i++ {
+		slash := strings.IndexByte(name, '/')
+		if slash < 0 {
+			return "", false
+		}
+		name = name[slash+1:]
+	}
+	for _, rule := range o.Rename {
+		if strings.HasPrefix(name, rule.Old) {
+			name = rule.New + name[len(rule.Old):]
+			break
+		}
+	}
+	return name, name != ""
+}
@@ -0,0 +1,210 @@
+package zipper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readTreeFiles returns the contents of the regular files below dir by their
+// slash-separated paths.
+func readTreeFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	for _, name := range listTree(t, dir) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		info, err := os.Lstat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(data)
+	}
+	return files
+}
+
+func TestExtractSelection(t *testing.T) {
+	project := []hostileEntry{
+		{name: "README", body: "readme"},
+		{name: "project/a.txt", body: "a"},
+		{name: "project/docs/b.md", body: "b"},
+		{name: "project/docs/c.txt", body: "c"},
+		{name: "project/img/d.jpg", body: "d"},
+	}
+	tests := []struct {
+		name    string
+		entries []hostileEntry
+		opts    ExtractOptions
+		want    map[string]string
+	}{
+		{
+			name:    "include by name",
+			entries: project,
+			opts:    ExtractOptions{Include: []string{"*.txt"}},
+			want:    map[string]string{"project/a.txt": "a", "project/docs/c.txt": "c"},
+		},
+		{
+			name:    "include a directory",
+			entries: project,
+			opts:    ExtractOptions{Include: []string{"project/docs"}},
+			want:    map[string]string{"project/docs/b.md": "b", "project/docs/c.txt": "c"},
+		},
+		{
+			name:    "exclude",
+			entries: project,
+			opts:    ExtractOptions{Exclude: []string{"*.jpg", "README"}},
+			want:    map[string]string{"project/a.txt": "a", "project/docs/b.md": "b", "project/docs/c.txt": "c"},
+		},
+		{
+			name:    "exclude within include",
+			entries: project,
+			opts:    ExtractOptions{Include: []string{"docs/"}, Exclude: []string{"*.md"}},
+			want:    map[string]string{"project/docs/c.txt": "c"},
+		},
+		{
+			name:    "include matching nothing",
+			entries: project,
+			opts:    ExtractOptions{Include: []string{"*.go"}},
+			want:    map[string]string{},
+		},
+		{
+			name:    "strip components",
+			entries: project,
+			opts:    ExtractOptions{StripComponents: 1},
+			want:    map[string]string{"a.txt": "a", "docs/b.md": "b", "docs/c.txt": "c", "img/d.jpg": "d"},
+		},
+		{
+			name:    "strip components beyond some entries",
+			entries: project,
+			opts:    ExtractOptions{StripComponents: 2},
+			want:    map[string]string{"b.md": "b", "c.txt": "c", "d.jpg": "d"},
+		},
+		{
+			name:    "strip components beyond every entry",
+			entries: project,
+			opts:    ExtractOptions{StripComponents: 5},
+			want:    map[string]string{},
+		},
+		{
+			name: "strip components after ./",
+			entries: []hostileEntry{
+				{name: "./project/a.txt", body: "a"},
+				{name: "././top.txt", body: "top"},
+			},
+			opts: ExtractOptions{StripComponents: 1},
+			want: map[string]string{"a.txt": "a"},
+		},
+		{
+			name:    "patterns match names before stripping",
+			entries: project,
+			opts:    ExtractOptions{Include: []string{"project/img"}, StripComponents: 1},
+			want:    map[string]string{"img/d.jpg": "d"},
+		},
+		{
+			name:    "rename",
+			entries: project,
+			opts:    ExtractOptions{Rename: []RenameRule{{Old: "project/", New: "src/"}}},
+			want:    map[string]string{"README": "readme", "src/a.txt": "a", "src/docs/b.md": "b", "src/docs/c.txt": "c", "src/img/d.jpg": "d"},
+		},
+		{
+			name:    "first matching rename rule wins",
+			entries: project,
+			opts: ExtractOptions{StripComponents: 1, Rename: []RenameRule{
+				{Old: "docs/", New: "manual/"},
+				{Old: "docs/b", New: "notes/b"},
+			}},
+			want: map[string]string{"a.txt": "a", "manual/b.md": "b", "manual/c.txt": "c", "img/d.jpg": "d"},
+		},
+		{
+			name: "renamed entries collide",
+			entries: []hostileEntry{
+				{name: "v1/a.txt", body: "one"},
+				{name: "v2/a.txt", body: "two"},
+				{name: "v1/only1.txt", body: "1"},
+			},
+			opts: ExtractOptions{Rename: []RenameRule{{Old: "v1/", New: "src/"}, {Old: "v2/", New: "src/"}}},
+			// The later entry replaces the earlier one, as repeated names do
+			want: map[string]string{"src/a.txt": "two", "src/only1.txt": "1"},
+		},
+		{
+			name: "renamed entry collides with another entry",
+			entries: []hostileEntry{
+				{name: "old/a.txt", body: "renamed"},
+				{name: "new/a.txt", body: "original"},
+			},
+			opts: ExtractOptions{Rename: []RenameRule{{Old: "old/", New: "new/"}}},
+			want: map[string]string{"new/a.txt": "original"},
+		},
+		{
+			name: "stripped entries collide",
+			entries: []hostileEntry{
+				{name: "a/x.txt", body: "from a"},
+				{name: "b/x.txt", body: "from b"},
+			},
+			opts: ExtractOptions{StripComponents: 1},
+			want: map[string]string{"x.txt": "from b"},
+		},
+	}
+	formats := []struct {
+		ext   string
+		write func(t *testing.T, path string, entries []hostileEntry)
+	}{
+		{".zip", writeZipEntries},
+		{".tar.gz", writeTarGzEntries},
+	}
+	for _, tt := range tests {
+		for _, format := range formats {
+			t.Run(tt.name+" "+format.ext, func(t *testing.T) {
+				root := t.TempDir()
+				archive := filepath.Join(root, "a"+format.ext)
+				format.write(t, archive, tt.entries)
+				destDir := filepath.Join(root, "out")
+
+				stats, err := ExtractArchive(archive, destDir, tt.opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := readTreeFiles(t, destDir); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("extracted %q, want %q", got, tt.want)
+				}
+				if len(stats.Conflicts) != 0 {
+					t.Errorf("conflicts %+v between entries of the archive", stats.Conflicts)
+				}
+			})
+		}
+	}
+}
+
+func TestEntryName(t *testing.T) {
+	tests := []struct {
+		name   string
+		strip  int
+		rename []RenameRule
+		want   string
+		wantOK bool
+	}{
+		{"a/b/c.txt", 0, nil, "a/b/c.txt", true},
+		{"a/b/c.txt", 1, nil, "b/c.txt", true},
+		{"a/b/c.txt", 2, nil, "c.txt", true},
+		{"a/b/c.txt", 3, nil, "", false},
+		{"a/b/", 2, nil, "", false},
+		{"a/b/", 1, nil, "b/", true},
+		{"./a/b.txt", 1, nil, "b.txt", true},
+		{"a/b.txt", 0, []RenameRule{{Old: "a/", New: ""}}, "b.txt", true},
+		{"a/", 0, []RenameRule{{Old: "a/", New: ""}}, "", false},
+		{"ab/c.txt", 0, []RenameRule{{Old: "a/", New: "x/"}}, "ab/c.txt", true},
+	}
+	for _, tt := range tests {
+		opts := ExtractOptions{StripComponents: tt.strip, Rename: tt.rename}
+		if got, ok := opts.entryName(tt.name); got != tt.want || ok != tt.wantOK {
+			t.Errorf("entryName(%q) with strip %d, rename %v = %q, %v; want %q, %v", tt.name, tt.strip, tt.rename, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
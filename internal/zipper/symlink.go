@@ -0,0 +1,236 @@
+package zipper
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy decides what happens to symbolic links found while walking
+// the sources of an archive. Links named directly as sources are always
+// followed.
+type SymlinkPolicy int
+
+const (
+	// SymlinksStore stores links as link entries holding their target, the
+	// same way tar and Info-ZIP do. The files they point to are not read.
+	SymlinksStore SymlinkPolicy = iota
+	// SymlinksFollow archives whatever a link points to as if it were a
+	// regular file or directory. Broken links and links back into a
+	// directory that is already being walked are skipped with a warning.
+	SymlinksFollow
+	// SymlinksSkip leaves links out of the archive.
+	SymlinksSkip
+)
+
+// ParseSymlinkPolicy parses "store", "follow" or "skip".
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch strings.ToLower(s) {
+	case "store", "":
+		return SymlinksStore, nil
+	case "follow":
+		return SymlinksFollow, nil
+	case "skip":
+		return SymlinksSkip, nil
+	}
+	return SymlinksStore, fmt.Errorf("unknown symlink policy %q (use store, follow or skip)", s)
+}
+
+func (p SymlinkPolicy) String() string {
+	switch p {
+	case SymlinksFollow:
+		return "follow"
+	case SymlinksSkip:
+		return "skip"
+	default:
+		return "store"
+	}
+}
+
+// symlinkEntry is a link read from an archive. Links are created only after
+// every other entry has been extracted, so no entry can be written through a
+// link the archive itself planted.
+type symlinkEntry struct {
+	name   string
+	target string
+	meta   entryMeta
+}
+
+// maxLinkTarget is the longest symlink target accepted from an archive.
+const maxLinkTarget = 4096
+
+// isZipSymlink reports whether a zip entry is a symlink.
+func isZipSymlink(f *zip.File) bool {
+	return f.Mode()&fs.ModeSymlink != 0
+}
+
+// readZipSymlink reads the target stored as the data of a zip symlink entry.
+func readZipSymlink(f *zip.File) (symlinkEntry, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return symlinkEntry{}, err
+	}
+	defer rc.Close()
+
+	target, err := io.ReadAll(io.LimitReader(rc, maxLinkTarget+1))
+	if err != nil {
+		return symlinkEntry{}, fmt.Errorf("%s: %w", f.Name, err)
+	}
+	if len(target) > maxLinkTarget {
+		return symlinkEntry{}, fmt.Errorf("%s: symlink target too long", f.Name)
+	}
+	return symlinkEntry{name: f.Name, target: string(target)}, nil
+}
+
+// unsafeLinkError describes a symlink that extraction refuses to create.
+type unsafeLinkError struct {
+	name, target, reason string
+}
+
+func (e *unsafeLinkError) Error() string {
+	return fmt.Sprintf("refusing symlink %s -> %s: %s", e.name, e.target, e.reason)
+}
+
+// createSymlinks recreates the links of an archive below destDir. Links that
+// are unsafe to create are reported and skipped.
+func createSymlinks(destDir string, links []symlinkEntry, restore *metadataRestorer, out *outputTracker, conflicts *conflictResolver) error {
+	for _, link := range links {
+		linkPath, err := createSymlink(destDir, link, out, conflicts)
+		var unsafe *unsafeLinkError
+		if errors.As(err, &unsafe) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if linkPath == "" {
+			continue
+		}
+		link.meta.path = linkPath
+		if err := restore.link(link.meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createSymlink creates the link name -> target below destDir and returns
+// its path, or "" if an existing file is kept in its place. It refuses
+// absolute targets, targets that lead out of destDir and links whose parent
+// directories are themselves links, since those could point anywhere.
+func createSymlink(destDir string, link symlinkEntry, out *outputTracker, conflicts *conflictResolver) (string, error) {
+	name, target := strings.TrimSuffix(link.name, "/"), link.target
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid file path: %s", name)
+	}
+
+	slashTarget := filepath.ToSlash(target)
+	if target == "" || path.IsAbs(slashTarget) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return "", &unsafeLinkError{name, target, "target must be a relative path"}
+	}
+	if reason, err := linkTargetProblem(destDir, name, slashTarget); err != nil {
+		return "", err
+	} else if reason != "" {
+		return "", &unsafeLinkError{name, target, reason}
+	}
+
+	if parent, err := symlinkParent(destDir, name); err != nil {
+		return "", err
+	} else if parent != "" {
+		return "", &unsafeLinkError{name, target, parent + " is a symlink"}
+	}
+
+	linkPath, err := conflicts.resolve(filepath.Join(destDir, filepath.FromSlash(name)), link.meta.modTime)
+	if err != nil || linkPath == "" {
+		return "", err
+	}
+	if err := out.mkdirAll(filepath.Dir(linkPath)); err != nil {
+		return "", err
+	}
+
+	// Replace an existing file or link, as extraction does for files
+	out.create(linkPath)
+	if info, err := os.Lstat(linkPath); err == nil {
+		if info.IsDir() {
+			return "", fmt.Errorf("cannot create symlink %s: a directory is in the way", name)
+		}
+		if err := os.Remove(linkPath); err != nil {
+			return "", err
+		}
+	}
+
+	return linkPath, os.Symlink(filepath.FromSlash(target), linkPath)
+}
+
+// linkTargetProblem follows the slash-separated target of the link name one
+// element at a time, the way the system will, and returns why it is unsafe,
+// or "" if it stays inside destDir. Text alone cannot tell where ".." after a
+// symlink leads, so ".." may only come first, and the target may not pass
+// through a symlink already on disk.
+func linkTargetProblem(destDir, name, target string) (string, error) {
+	dir := path.Dir(name)
+	descended := false
+	elems := strings.Split(target, "/")
+	for i, elem := range elems {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if descended {
+				return "target goes back up after a directory name", nil
+			}
+			if dir == "." {
+				return "target is outside the destination", nil
+			}
+			dir = path.Dir(dir)
+			continue
+		}
+		descended = true
+		dir = path.Join(dir, elem)
+		if i == len(elems)-1 {
+			// The link may point at another link, which was checked itself
+			break
+		}
+		info, err := os.Lstat(filepath.Join(destDir, filepath.FromSlash(dir)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "target passes through the symlink " + dir, nil
+		}
+	}
+	return "", nil
+}
+
+// symlinkParent returns the first directory between destDir and the entry
+// name that is a symbolic link, relative to destDir, or "" if there is none.
+func symlinkParent(destDir, name string) (string, error) {
+	dir := "."
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			continue
+		}
+		dir = path.Join(dir, part)
+		info, err := os.Lstat(filepath.Join(destDir, filepath.FromSlash(dir)))
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return dir, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,47 @@
+package zipper
+
+import (
+	"archive/tar"
+	"strings"
+)
+
+// paxXattrPrefix introduces a PAX record holding an extended attribute, in
+// the format used by star, GNU tar and bsdtar.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// addXattrRecords stores the extended attributes of the file at path in the
+// PAX records of header. POSIX ACLs are kept in the system.posix_acl_access
+// and system.posix_acl_default attributes, so they are captured as well.
+func addXattrRecords(header *tar.Header, path string, follow bool) error {
+	attrs, err := readXattrs(path, follow)
+	if err != nil {
+		return err
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	if header.PAXRecords == nil {
+		header.PAXRecords = make(map[string]string, len(attrs))
+	}
+	for name, value := range attrs {
+		header.PAXRecords[paxXattrPrefix+name] = value
+	}
+	header.Format = tar.FormatPAX
+	return nil
+}
+
+// xattrRecords returns the extended attributes stored in the PAX records of header.
+func xattrRecords(header *tar.Header) map[string]string {
+	var attrs map[string]string
+	for key, value := range header.PAXRecords {
+		name, ok := strings.CutPrefix(key, paxXattrPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		attrs[name] = value
+	}
+	return attrs
+}
@@ -0,0 +1,60 @@
+package zipper
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of the file at path. With
+// follow unset, the attributes of a symlink itself are read.
+func readXattrs(path string, follow bool) (map[string]string, error) {
+	list, get := unix.Llistxattr, unix.Lgetxattr
+	if follow {
+		list, get = unix.Listxattr, unix.Getxattr
+	}
+
+	size, err := list(path, nil)
+	if err != nil || size == 0 {
+		if isXattrUnsupported(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = list(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]string)
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		n, err := get(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, n)
+		if n > 0 {
+			if n, err = get(path, name, value); err != nil {
+				return nil, err
+			}
+		}
+		attrs[name] = string(value[:n])
+	}
+	return attrs, nil
+}
+
+// writeXattr sets one extended attribute on the file at path.
+func writeXattr(path, name, value string) error {
+	return unix.Setxattr(path, name, []byte(value), 0)
+}
+
+// isXattrUnsupported reports whether err means the filesystem has no
+// extended attributes.
+func isXattrUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package zipper
+
+import "errors"
+
+var errXattrsUnsupported = errors.New("extended attributes are only supported on Linux")
+
+func readXattrs(path string, follow bool) (map[string]string, error) {
+	return nil, errXattrsUnsupported
+}
+
+func writeXattr(path, name, value string) error {
+	return errXattrsUnsupported
+}
+
+func isXattrUnsupported(err error) bool {
+	return errors.Is(err, errXattrsUnsupported)
+}
@@ -0,0 +1,196 @@
+package zipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// zipEntryCompressor compresses zip entries on the reader workers so that
+// DEFLATE work scales with the worker count. Small files are compressed into
+// memory; large files are compressed into a spool file next to the archive.
+//
+// With uniformStore, stored files read into memory are left for the writer
+// to write with a data descriptor, the same way as stored files too large to
+// read ahead, so the layout of the archive does not depend on the memory
+// budget or the worker count.
+type zipEntryCompressor struct {
+	level        int
+	uniformStore bool
+	spoolDir     string
+	writers      sync.Pool
+	onSpool      func(n int64)
+}
+
+func newZipEntryCompressor(level int, uniformStore bool, spoolDir string, onSpool func(n int64)) *zipEntryCompressor {
+	return &zipEntryCompressor{level: level, uniformStore: uniformStore, spoolDir: spoolDir, onSpool: onSpool}
+}
+
+// flateWriter returns a pooled DEFLATE writer reset to write into w.
+func (c *zipEntryCompressor) flateWriter(w io.Writer) (*flate.Writer, error) {
+	if fw, ok := c.writers.Get().(*flate.Writer); ok {
+		fw.Reset(w)
+		return fw, nil
+	}
+	return flate.NewWriter(w, c.level)
+}
+
+// process is a fileProcessor that fills in the raw fields of fd.
+func (c *zipEntryCompressor) process(fd *fileData) error {
+	fd.method = getCompressionMethod(fd.job.path)
+
+	if fd.stream {
+		if fd.method == zip.Store {
+			// Nothing to gain from a spool; the writer streams it directly.
+			return nil
+		}
+		return c.spool(fd)
+	}
+
+	if fd.method == zip.Store && c.uniformStore {
+		return nil
+	}
+
+	fd.raw = true
+	fd.size = int64(len(fd.data))
+	fd.crc = crc32.ChecksumIEEE(fd.data)
+	if fd.method == zip.Store {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(fd.data) / 2)
+	fw, err := c.flateWriter(&buf)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(fd.data); err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	c.writers.Put(fw)
+	fd.data = buf.Bytes()
+	return nil
+}
+
+// spool compresses a large file into a temporary file.
+func (c *zipEntryCompressor) spool(fd *fileData) error {
+	src, ok := openStream(fd.job)
+	if !ok {
+		return errSkipFile
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(c.spoolDir, ".pz-spool-*")
+	if err != nil {
+		return err
+	}
+	fd.spool = tmp
+
+	fw, err := c.flateWriter(tmp)
+	if err != nil {
+		return err
+	}
+	hash := crc32.NewIEEE()
+	size, err := streamFile(io.MultiWriter(fw, hash), src, c.onSpool)
+	if err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	c.writers.Put(fw)
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	fd.raw = true
+	fd.stream = false
+	fd.size = size
+	fd.crc = hash.Sum32()
+	return nil
+}
+
+// writeRawEntry writes a pre-compressed entry using header for everything but
+// the sizes, method and CRC, which come from fd.
+func writeRawEntry(w *zip.Writer, header *zip.FileHeader, fd *fileData) error {
+	header.Method = fd.method
+	header.CRC32 = fd.crc
+	header.UncompressedSize64 = uint64(fd.size)
+	if fd.spool != nil {
+		info, err := fd.spool.Stat()
+		if err != nil {
+			return err
+		}
+		header.CompressedSize64 = uint64(info.Size())
+	} else {
+		header.CompressedSize64 = uint64(len(fd.data))
+	}
+	prepareRawHeader(header)
+
+	entry, err := w.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	if fd.spool != nil {
+		_, err = io.CopyBuffer(entry, fd.spool, make([]byte, streamBufferSize))
+		return err
+	}
+	_, err = entry.Write(fd.data)
+	return err
+}
+
+// prepareRawHeader fills in the header fields that zip.Writer.CreateHeader
+// would normally set, so raw entries look the same as compressed ones.
+func prepareRawHeader(fh *zip.FileHeader) {
+	const (
+		zipVersion20   = 20
+		extTimeExtraID = 0x5455
+	)
+
+	fh.CreatorVersion = fh.CreatorVersion&0xff00 | zipVersion20
+	fh.ReaderVersion = zipVersion20
+	fh.Flags &^= 0x8 // sizes are known, so no data descriptor
+
+	if !fh.NonUTF8 && utf8.ValidString(fh.Name) && !isASCII(fh.Name) {
+		fh.Flags |= 0x800
+	}
+
+	if !fh.Modified.IsZero() {
+		fh.ModifiedDate, fh.ModifiedTime = msDosTime(fh.Modified)
+
+		// Info-ZIP extended timestamp, as written by CreateHeader
+		var extra [9]byte
+		binary.LittleEndian.PutUint16(extra[0:], extTimeExtraID)
+		binary.LittleEndian.PutUint16(extra[2:], 5)
+		extra[4] = 1
+		binary.LittleEndian.PutUint32(extra[5:], uint32(fh.Modified.Unix()))
+		fh.Extra = append(fh.Extra, extra[:]...)
+	}
+}
+
+// msDosTime converts t to the MS-DOS date and time fields of a zip header.
+func msDosTime(t time.Time) (date, tod uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tod = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tod
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -3,10 +3,9 @@ package zipper
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"compress/flate"
-	"compress/gzip"
 	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
@@ -85,22 +84,41 @@ func shouldSkip(name string, isDir bool) bool {
 	return false
 }
 
+// numCPU returns the number of usable CPUs. Tests replace it to try other
+// machine sizes.
+var numCPU = runtime.NumCPU
+
 // getWorkerCount returns the number of workers to use (20% of CPU cores, minimum 1)
 func getWorkerCount() int {
-	numCPU := runtime.NumCPU()
-	workers := numCPU / 5
+	workers := numCPU() / 5
 	if workers < 1 {
 		workers = 1
 	}
 	return workers
 }
 
+// getCompressorCount returns the number of goroutines that compress a single
+// gzip or zstd stream. The stream is the bottleneck of a tarball, so it gets
+// every core rather than a share of the reader workers.
+func getCompressorCount() int {
+	return numCPU()
+}
+
 // fileJob represents a file to be compressed
 type fileJob struct {
 	path  string
 	rel   string
 	info  fs.FileInfo
 	isDir bool
+	link  string // target of a stored symlink
+
+	// hardLink is the entry name of an earlier copy of the same file
+	hardLink string
+}
+
+// isRegular reports whether the job has file data to read.
+func (j fileJob) isRegular() bool {
+	return !j.isDir && j.link == "" && j.hardLink == ""
 }
 
 // getCompressionMethod returns the optimal compression method for a file
@@ -153,22 +171,74 @@ func ZipWithProgress(srcDir, zipPath string, progress ProgressFunc) (stats Archi
 
 // ZipWithProgressAndFile creates a zip archive and reports progress with current file information.
 func ZipWithProgressAndFile(srcDir, zipPath string, progress ProgressWithFileFunc) (stats ArchiveStats, err error) {
-	stats, err = scanDirectory(srcDir)
+	return ZipWithOptions(srcDir, zipPath, CreateOptions{Progress: progress})
+}
+
+// ZipWithOptions creates a zip archive of srcDir as configured by opts.
+func ZipWithOptions(srcDir, zipPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	return ZipFiles([]string{srcDir}, zipPath, opts)
+}
+
+// ZipFiles creates a zip archive of any number of files and directories. A
+// single directory is archived by its contents; otherwise every source is
+// stored under its base name.
+func ZipFiles(sources []string, zipPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	ex, err := opts.Exclude.compile()
 	if err != nil {
 		return stats, err
 	}
+	ex.skipOutputs(zipPath)
+	files, err := collectFiles(sources, ex, opts.Symlinks)
+	if err != nil {
+		return stats, err
+	}
+	norm := opts.normalizer()
+	norm.sortFiles(files)
+	if opts.DedupHardLinks {
+		markHardLinks(files, true)
+	}
+	stats = totalFiles(files)
 
 	zipFile, err := os.Create(zipPath)
 	if err != nil {
 		return stats, err
 	}
+	defer func() {
+		zipFile.Close()
+		if err != nil {
+			// Do not leave a truncated archive behind
+			os.Remove(zipPath)
+		}
+	}()
 
-	writer := zip.NewWriter(zipFile)
-	// Register custom compressor with optimal level based on total size
-	compressionLevel := getOptimalCompressionLevel(stats.TotalBytes)
-	writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
-		return flate.NewWriter(out, compressionLevel)
-	})
+	// Hash the archive as it is written; the checksum goes into the comment
+	hash := sha256.New()
+	writer := zip.NewWriter(io.MultiWriter(zipFile, hash))
+	if err := writeZipFiles(writer, files, stats.TotalBytes, filepath.Dir(zipPath), opts); err != nil {
+		return stats, err
+	}
+
+	// Close the writer before finishing the archive with its checksum
+	if err := writer.Close(); err != nil {
+		return stats, err
+	}
+	stats.Checksum, err = appendZipChecksum(zipFile, hash.Sum(nil))
+	if err != nil {
+		return stats, fmt.Errorf("failed to add checksum: %w", err)
+	}
+	if err := zipFile.Close(); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// writeZipFiles reads and compresses files on the worker pool and writes them
+// to writer in order, reporting progress against total. Large files may be
+// spooled to temporary files in spoolDir while they are compressed.
+func writeZipFiles(writer *zip.Writer, files []fileJob, total int64, spoolDir string, opts CreateOptions) error {
+	progress := opts.Progress
+	norm := opts.normalizer()
 
 	done := int64(0)
 	var doneMutex sync.Mutex
@@ -179,360 +249,121 @@ func ZipWithProgressAndFile(srcDir, zipPath string, progress ProgressWithFileFun
 		if progress != nil {
 			doneMutex.Lock()
 			currentFileMutex.Lock()
-			progress(done, stats.TotalBytes, currentFile)
+			progress(done, total, currentFile)
 			currentFileMutex.Unlock()
 			doneMutex.Unlock()
 		}
 	}
 	callProgress()
 
-	// Collect all files first
-	var files []fileJob
-	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-
-		rel, err := filepath.Rel(srcDir, path)
-		if err != nil {
-			return err
-		}
-
-		if rel == "." {
-			return nil
-		}
-
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
-
-		files = append(files, fileJob{
-			path:  path,
-			rel:   rel,
-			info:  info,
-			isDir: d.IsDir(),
-		})
-		return nil
-	})
-	if err != nil {
-		return stats, err
-	}
-
-	// Process files with worker pool for reading
-	workerCount := getWorkerCount()
-	type fileData struct {
-		job  fileJob
-		data []byte
-		err  error
-	}
-
-	dataChan := make(chan fileData, workerCount)
-	var wg sync.WaitGroup
-
-	// Start workers to read files in parallel
-	jobChan := make(chan fileJob, len(files))
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for job := range jobChan {
-				if job.isDir {
-					dataChan <- fileData{job: job}
-					continue
-				}
-
-				data, err := os.ReadFile(job.path)
-				if err != nil {
-					// Skip inaccessible files instead of failing
-					fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", job.path, err)
-					continue
-				}
-				dataChan <- fileData{
-					job:  job,
-					data: data,
-					err:  nil,
-				}
-			}
-		}()
+	addDone := func(n int64) {
+		doneMutex.Lock()
+		done += n
+		doneMutex.Unlock()
+		callProgress()
 	}
 
-	// Send jobs to workers
-	go func() {
-		for _, file := range files {
-			jobChan <- file
-		}
-		close(jobChan)
-	}()
-
-	// Close data channel when all workers finish
-	go func() {
-		wg.Wait()
-		close(dataChan)
-	}()
+	// Read and compress entries in parallel within the memory budget; workers
+	// compress with the optimal level based on total size. A reproducible
+	// archive must not depend on which files fit in the budget.
+	compressor := newZipEntryCompressor(getOptimalCompressionLevel(total), norm != nil, spoolDir, addDone)
+	pipe := readFiles(files, opts.memoryLimit(), readAheadLimit(opts.memoryLimit()/2, getWorkerCount()), compressor.process)
+	defer pipe.close()
 
 	// Write to zip sequentially (required by zip format)
-	processedCount := 0
-	for fd := range dataChan {
-
-		header, err := zip.FileInfoHeader(fd.job.info)
-		if err != nil {
-			return stats, err
-		}
+	for fd := range pipe.out {
+		currentFileMutex.Lock()
+		currentFile = fd.job.rel
+		currentFileMutex.Unlock()
 
-		header.Name = filepath.ToSlash(fd.job.rel)
-		if fd.job.isDir {
-			header.Name += "/"
-		} else {
-			header.Method = getCompressionMethod(fd.job.path)
-		}
-
-		writerEntry, err := writer.CreateHeader(header)
+		err := writeZipEntry(writer, &fd, norm, addDone)
+		pipe.done(&fd)
 		if err != nil {
-			return stats, err
-		}
-
-		if !fd.job.isDir {
-			_, err = writerEntry.Write(fd.data)
-			if err != nil {
-				return stats, err
-			}
-
-			doneMutex.Lock()
-			done += int64(len(fd.data))
-			doneMutex.Unlock()
-
-			currentFileMutex.Lock()
-			currentFile = fd.job.rel
-			currentFileMutex.Unlock()
-
-			if progress != nil {
-				callProgress()
-			}
+			return err
 		}
-
-		processedCount++
 	}
 
 	callProgress()
+	return nil
+}
 
-	// Close writer and file explicitly before calculating checksum
-	if err := writer.Close(); err != nil {
-		return stats, err
-	}
-	if err := zipFile.Close(); err != nil {
-		return stats, err
+// writeZipEntry writes one entry produced by readFiles. Entries compressed by
+// the workers are written raw; large stored files are streamed from disk.
+func writeZipEntry(writer *zip.Writer, fd *fileData, norm *normalizer, addDone func(n int64)) error {
+	if fd.err != nil {
+		return fd.err
 	}
 
-	// Calculate checksum of the created archive
-	stats.Checksum, err = calculateFileChecksum(zipPath)
+	header, err := zip.FileInfoHeader(fd.job.info)
 	if err != nil {
-		return stats, fmt.Errorf("checksum calculation failed: %w", err)
+		return err
 	}
+	header.Name = filepath.ToSlash(fd.job.rel)
+	norm.zipHeader(header)
 
-	// Store checksum in zip comment
-	if err := addChecksumToZip(zipPath, stats.Checksum); err != nil {
-		return stats, fmt.Errorf("failed to add checksum: %w", err)
+	if fd.job.isDir {
+		header.Name += "/"
+		_, err := writer.CreateHeader(header)
+		return err
 	}
 
-	return stats, nil
-}
-
-func scanDirectory(root string) (ArchiveStats, error) {
-	stats := ArchiveStats{}
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-		if d.IsDir() {
-			return nil
-		}
-		info, err := d.Info()
+	if fd.job.link != "" {
+		// Info-ZIP stores the link target as the entry data. The entry may
+		// stand for a deduplicated hard link, so set the link mode here.
+		header.SetMode(fs.ModeSymlink | 0777)
+		header.Method = zip.Store
+		header.UncompressedSize64 = uint64(len(fd.job.link))
+		entry, err := writer.CreateHeader(header)
 		if err != nil {
 			return err
 		}
-		stats.TotalBytes += info.Size()
-		stats.FileCount++
-		return nil
-	})
-	return stats, err
-}
-
-// ExtractStats describes the data extracted from an archive.
-type ExtractStats struct {
-	TotalBytes int64
-	FileCount  int
-}
-
-// Extract extracts a zip archive to the destination directory.
-func Extract(zipPath, destDir string) error {
-	_, err := ExtractWithProgress(zipPath, destDir, nil)
-	return err
-}
-
-// ExtractWithProgress extracts a zip archive and reports progress via callback.
-func ExtractWithProgress(zipPath, destDir string, progress ProgressFunc) (stats ExtractStats, err error) {
-	reader, err := zip.OpenReader(zipPath)
-	if err != nil {
-		return stats, err
+		_, err = io.WriteString(entry, filepath.ToSlash(fd.job.link))
+		return err
 	}
-	defer reader.Close()
 
-	// Calculate total size
-	totalBytes := int64(0)
-	fileCount := 0
-	for _, f := range reader.File {
-		if !f.FileInfo().IsDir() {
-			totalBytes += int64(f.UncompressedSize64)
-			fileCount++
+	if fd.raw {
+		if err := writeRawEntry(writer, header, fd); err != nil {
+			return err
 		}
-	}
-
-	stats.TotalBytes = totalBytes
-	stats.FileCount = fileCount
-
-	done := int64(0)
-	var doneMutex sync.Mutex
-	callProgress := func() {
-		if progress != nil {
-			doneMutex.Lock()
-			progress(done, totalBytes)
-			doneMutex.Unlock()
+		if fd.spool == nil {
+			// Spooled files reported progress while being compressed
+			addDone(fd.size)
 		}
+		return nil
 	}
-	callProgress()
 
-	// Create directories first
-	for _, f := range reader.File {
-		if f.FileInfo().IsDir() {
-			destPath := filepath.Join(destDir, filepath.FromSlash(f.Name))
-			if !filepath.IsLocal(f.Name) {
-				return stats, fmt.Errorf("invalid file path: %s", f.Name)
-			}
-			if err := os.MkdirAll(destPath, f.Mode()); err != nil {
-				return stats, err
-			}
+	// A stored file, streamed from disk unless it was read ahead
+	var src io.Reader = bytes.NewReader(fd.data)
+	if fd.stream {
+		f, ok := openStream(fd.job)
+		if !ok {
+			return nil
 		}
+		defer f.Close()
+		src = f
 	}
 
-	// Extract files in parallel
-	workerCount := getWorkerCount()
-	type extractJob struct {
-		file     *zip.File
-		destPath string
-	}
-
-	jobChan := make(chan extractJob, len(reader.File))
-	errChan := make(chan error, 1)
-	var wg sync.WaitGroup
-
-	// Start workers
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for job := range jobChan {
-				rc, err := job.file.Open()
-				if err != nil {
-					select {
-					case errChan <- err:
-					default:
-					}
-					return
-				}
-
-				outFile, err := os.OpenFile(job.destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, job.file.Mode())
-				if err != nil {
-					rc.Close()
-					select {
-					case errChan <- err:
-					default:
-					}
-					return
-				}
-
-				written, err := io.Copy(outFile, rc)
-				rc.Close()
-				outFile.Close()
-
-				if err != nil {
-					select {
-					case errChan <- err:
-					default:
-					}
-					return
-				}
-
-				doneMutex.Lock()
-				done += written
-				doneMutex.Unlock()
-
-				if progress != nil {
-					callProgress()
-				}
-			}
-		}()
+	header.Method = zip.Store
+	writerEntry, err := writer.CreateHeader(header)
+	if err != nil {
+		return err
 	}
-
-	// Send jobs
-	go func() {
-		for _, f := range reader.File {
-			if f.FileInfo().IsDir() {
-				continue
-			}
-
-			destPath := filepath.Join(destDir, filepath.FromSlash(f.Name))
-
-			// Security check: prevent path traversal
-			if !filepath.IsLocal(f.Name) {
-				select {
-				case errChan <- fmt.Errorf("invalid file path: %s", f.Name):
-				default:
-				}
-				break
-			}
-
-			// Ensure parent directory exists
-			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-				select {
-				case errChan <- err:
-				default:
-				}
-				break
-			}
-
-			jobChan <- extractJob{file: f, destPath: destPath}
-		}
-		close(jobChan)
-	}()
-
-	// Wait for completion
-	wg.Wait()
-	close(errChan)
-
-	// Check for errors
-	if err := <-errChan; err != nil {
-		return stats, err
+	if _, err := streamFile(writerEntry, src, addDone); err != nil {
+		return fmt.Errorf("%s: %w", fd.job.rel, err)
 	}
-
-	callProgress()
-	return stats, nil
+	return nil
 }
 
-type progressReader struct {
-	r        io.Reader
-	done     *int64
-	total    int64
-	progress ProgressFunc
-}
-
-func (pr *progressReader) Read(p []byte) (int, error) {
-	n, err := pr.r.Read(p)
-	if pr.progress != nil && n > 0 {
-		*pr.done += int64(n)
-		pr.progress(*pr.done, pr.total)
+// totalFiles totals the regular files among files.
+func totalFiles(files []fileJob) ArchiveStats {
+	stats := ArchiveStats{}
+	for _, job := range files {
+		if job.isRegular() {
+			stats.TotalBytes += job.info.Size()
+			stats.FileCount++
+		}
 	}
-	return n, err
+	return stats
 }
 
 // Gzip creates a tar.gz archive of the source directory
@@ -552,24 +383,83 @@ func GzipWithProgress(srcDir, gzipPath string, progress ProgressFunc) (stats Arc
 
 // GzipWithProgressAndFile creates a tar.gz archive and reports progress with current file information
 func GzipWithProgressAndFile(srcDir, gzipPath string, progress ProgressWithFileFunc) (stats ArchiveStats, err error) {
-	stats, err = scanDirectory(srcDir)
+	return GzipWithOptions(srcDir, gzipPath, CreateOptions{Progress: progress})
+}
+
+// GzipWithOptions creates a tar.gz archive of srcDir as configured by opts.
+func GzipWithOptions(srcDir, gzipPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	return GzipFiles([]string{srcDir}, gzipPath, opts)
+}
+
+// GzipFiles creates a tar.gz archive of any number of files and directories,
+// naming entries the same way as ZipFiles.
+func GzipFiles(sources []string, gzipPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	return writeTarArchive(sources, gzipPath, opts, func(w io.Writer, totalBytes int64) (io.WriteCloser, error) {
+		// Use optimal compression level based on total size, compressing
+		// blocks of the tar stream on every core
+		return newParallelGzipWriter(w, getOptimalCompressionLevel(totalBytes), getCompressorCount())
+	})
+}
+
+// TarFiles creates an uncompressed tar archive of any number of files and
+// directories, naming entries the same way as ZipFiles. It suits data that
+// is already compressed, or a tar that is piped into another compressor.
+func TarFiles(sources []string, tarPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	return writeTarArchive(sources, tarPath, opts, nil)
+}
+
+// streamCompressor wraps the archive file w in a compressing writer, which
+// may pick its settings by the total size of the sources.
+type streamCompressor func(w io.Writer, totalBytes int64) (io.WriteCloser, error)
+
+// writeTarArchive creates a tar archive of sources at archivePath, compressed
+// by compress unless it is nil, and writes its checksum to a .sha256 file.
+func writeTarArchive(sources []string, archivePath string, opts CreateOptions, compress streamCompressor) (stats ArchiveStats, err error) {
+	progress := opts.Progress
+	ex, err := opts.Exclude.compile()
 	if err != nil {
 		return stats, err
 	}
-
-	gzipFile, err := os.Create(gzipPath)
+	ex.skipOutputs(archivePath)
+	files, err := collectFiles(sources, ex, opts.Symlinks)
 	if err != nil {
 		return stats, err
 	}
+	norm := opts.normalizer()
+	norm.sortFiles(files)
+	markHardLinks(files, false)
+	stats = totalFiles(files)
 
-	// Use optimal compression level based on total size
-	compressionLevel := getOptimalCompressionLevel(stats.TotalBytes)
-	gzWriter, err := gzip.NewWriterLevel(gzipFile, compressionLevel)
+	archiveFile, err := os.Create(archivePath)
 	if err != nil {
 		return stats, err
 	}
+	defer func() {
+		archiveFile.Close()
+		if err != nil {
+			// Do not leave a truncated archive behind
+			os.Remove(archivePath)
+		}
+	}()
+
+	var out io.Writer = archiveFile
+	var compressor io.WriteCloser
+	if compress != nil {
+		compressor, err = compress(archiveFile, stats.TotalBytes)
+		if err != nil {
+			return stats, err
+		}
+		// Stop the compressor's goroutines if writing fails; runs before
+		// the file is closed
+		defer func() {
+			if compressor != nil {
+				compressor.Close()
+			}
+		}()
+		out = compressor
+	}
 
-	tarWriter := tar.NewWriter(gzWriter)
+	tarWriter := tar.NewWriter(out)
 
 	done := int64(0)
 	var doneMutex sync.Mutex
@@ -587,123 +477,28 @@ func GzipWithProgressAndFile(srcDir, gzipPath string, progress ProgressWithFileF
 	}
 	callProgress()
 
-	// Collect all files first
-	var files []fileJob
-	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-
-		rel, err := filepath.Rel(srcDir, path)
-		if err != nil {
-			return err
-		}
-
-		if rel == "." {
-			return nil
-		}
-
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
-
-		files = append(files, fileJob{
-			path:  path,
-			rel:   rel,
-			info:  info,
-			isDir: d.IsDir(),
-		})
-		return nil
-	})
-	if err != nil {
-		return stats, err
-	}
+	// Read small files ahead in parallel within the memory budget
+	pipe := readFiles(files, opts.memoryLimit(), readAheadLimit(opts.memoryLimit(), getWorkerCount()), nil)
+	defer pipe.close()
 
-	// Process files with worker pool for reading
-	workerCount := getWorkerCount()
-	type fileData struct {
-		job  fileJob
-		data []byte
-		err  error
-	}
-
-	dataChan := make(chan fileData, workerCount)
-	var wg sync.WaitGroup
-
-	// Start workers to read files in parallel
-	jobChan := make(chan fileJob, len(files))
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for job := range jobChan {
-				if job.isDir {
-					dataChan <- fileData{job: job}
-					continue
-				}
-
-				data, err := os.ReadFile(job.path)
-				if err != nil {
-					// Skip inaccessible files instead of failing
-					fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", job.path, err)
-					continue
-				}
-				dataChan <- fileData{
-					job:  job,
-					data: data,
-					err:  nil,
-				}
-			}
-		}()
+	addDone := func(n int64) {
+		doneMutex.Lock()
+		done += n
+		doneMutex.Unlock()
+		callProgress()
 	}
 
-	// Send jobs to workers
-	go func() {
-		for _, file := range files {
-			jobChan <- file
-		}
-		close(jobChan)
-	}()
-
-	// Close data channel when all workers finish
-	go func() {
-		wg.Wait()
-		close(dataChan)
-	}()
-
 	// Write to tar sequentially (required by tar format)
-	for fd := range dataChan {
+	for fd := range pipe.out {
+		currentFileMutex.Lock()
+		currentFile = fd.job.rel
+		currentFileMutex.Unlock()
 
-		header, err := tar.FileInfoHeader(fd.job.info, "")
+		err := writeTarEntry(tarWriter, &fd, norm, opts.Xattrs, addDone)
+		pipe.done(&fd)
 		if err != nil {
 			return stats, err
 		}
-
-		header.Name = filepath.ToSlash(fd.job.rel)
-
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return stats, err
-		}
-
-		if !fd.job.isDir {
-			_, err = tarWriter.Write(fd.data)
-			if err != nil {
-				return stats, err
-			}
-
-			doneMutex.Lock()
-			done += int64(len(fd.data))
-			doneMutex.Unlock()
-
-			currentFileMutex.Lock()
-			currentFile = fd.job.rel
-			currentFileMutex.Unlock()
-
-			if progress != nil {
-				callProgress()
-			}
-		}
 	}
 
 	callProgress()
@@ -712,273 +507,91 @@ func GzipWithProgressAndFile(srcDir, gzipPath string, progress ProgressWithFileF
 	if err := tarWriter.Close(); err != nil {
 		return stats, err
 	}
-	if err := gzWriter.Close(); err != nil {
-		return stats, err
+	if compressor != nil {
+		err := compressor.Close()
+		compressor = nil
+		if err != nil {
+			return stats, err
+		}
 	}
-	if err := gzipFile.Close(); err != nil {
+	if err := archiveFile.Close(); err != nil {
 		return stats, err
 	}
 
 	// Calculate checksum of the created archive
-	stats.Checksum, err = calculateFileChecksum(gzipPath)
+	stats.Checksum, err = calculateFileChecksum(archivePath)
 	if err != nil {
 		return stats, fmt.Errorf("checksum calculation failed: %w", err)
 	}
 
 	// Store checksum in a separate .sha256 file
-	if err := writeChecksumFile(gzipPath, stats.Checksum); err != nil {
+	if err := writeChecksumFile(archivePath, stats.Checksum); err != nil {
 		return stats, fmt.Errorf("failed to write checksum file: %w", err)
 	}
 
 	return stats, nil
 }
 
-// ExtractGzip extracts a tar.gz archive to the destination directory
-func ExtractGzip(gzipPath, destDir string) error {
-	_, err := ExtractGzipWithProgress(gzipPath, destDir, nil)
-	return err
-}
-
-// ExtractGzipWithProgress extracts a tar.gz archive and reports progress via callback
-func ExtractGzipWithProgress(gzipPath, destDir string, progress ProgressFunc) (stats ExtractStats, err error) {
-	gzipFile, err := os.Open(gzipPath)
-	if err != nil {
-		return stats, err
-	}
-	defer gzipFile.Close()
-
-	// First pass: calculate total size
-	gzReader, err := gzip.NewReader(gzipFile)
-	if err != nil {
-		return stats, err
-	}
-	defer gzReader.Close()
-
-	tarReader := tar.NewReader(gzReader)
-	totalBytes := int64(0)
-	fileCount := 0
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return stats, err
-		}
-		if header.Typeflag == tar.TypeReg {
-			totalBytes += header.Size
-			fileCount++
-		}
-	}
-
-	stats.TotalBytes = totalBytes
-	stats.FileCount = fileCount
-
-	// Reopen for actual extraction
-	gzipFile.Seek(0, 0)
-	gzReader2, err := gzip.NewReader(gzipFile)
-	if err != nil {
-		return stats, err
-	}
-	defer gzReader2.Close()
-
-	tarReader2 := tar.NewReader(gzReader2)
-
-	done := int64(0)
-	callProgress := func() {
-		if progress != nil {
-			progress(done, totalBytes)
-		}
-	}
-	callProgress()
-
-	for {
-		header, err := tarReader2.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return stats, err
-		}
-
-		destPath := filepath.Join(destDir, filepath.FromSlash(header.Name))
-
-		// Security check: prevent path traversal
-		if !filepath.IsLocal(header.Name) {
-			return stats, fmt.Errorf("invalid file path: %s", header.Name)
-		}
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(destPath, os.FileMode(header.Mode)); err != nil {
-				return stats, err
-			}
-		case tar.TypeReg:
-			// Ensure parent directory exists
-			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-				return stats, err
-			}
-
-			outFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode))
-			if err != nil {
-				return stats, err
-			}
-
-			pr := &progressReader{
-				r:        tarReader2,
-				done:     &done,
-				total:    totalBytes,
-				progress: progress,
-			}
-
-			if _, err = io.Copy(outFile, pr); err != nil {
-				outFile.Close()
-				return stats, err
-			}
-			if err := outFile.Close(); err != nil {
-				return stats, err
-			}
+// writeTarEntry writes one file or directory to the tar stream, streaming
+// large files from disk. With xattrs set, extended attributes are stored in
+// PAX records.
+func writeTarEntry(tw *tar.Writer, fd *fileData, norm *normalizer, xattrs bool, addDone func(int64)) error {
+	var src *os.File
+	if fd.stream {
+		var ok bool
+		if src, ok = openStream(fd.job); !ok {
+			return nil
 		}
+		defer src.Close()
 	}
 
-	callProgress()
-	return stats, nil
-}
-
-// calculateFileChecksum computes SHA-256 checksum of a file
-func calculateFileChecksum(filePath string) (string, error) {
-	file, err := os.Open(filePath)
+	header, err := tar.FileInfoHeader(fd.job.info, filepath.ToSlash(fd.job.link))
 	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
-	}
-
-	return hex.EncodeToString(hash.Sum(nil)), nil
-}
-
-// addChecksumToZip adds the checksum to the zip file comment
-func addChecksumToZip(zipPath, checksum string) error {
-	// Read the zip file
-	r, err := zip.OpenReader(zipPath)
-	if err != nil {
-		return err
-	}
-
-	// Create a temporary file
-	tempPath := zipPath + ".tmp"
-	tempFile, err := os.Create(tempPath)
-	if err != nil {
-		r.Close()
 		return err
 	}
 
-	// Create new zip writer
-	w := zip.NewWriter(tempFile)
-	w.SetComment(fmt.Sprintf("SHA256: %s", checksum))
+	header.Name = filepath.ToSlash(fd.job.rel)
+	norm.tarHeader(header)
 
-	// Copy all files from original zip
-	for _, f := range r.File {
-		if err := copyZipFile(w, f); err != nil {
-			w.Close()
-			tempFile.Close()
-			r.Close()
-			os.Remove(tempPath)
-			return err
+	if fd.job.hardLink != "" {
+		header.Typeflag = tar.TypeLink
+		header.Linkname = fd.job.hardLink
+		header.Size = 0
+	} else if xattrs {
+		if err := addXattrRecords(header, fd.job.path, fd.job.link == ""); err != nil {
+			return fmt.Errorf("%s: %w", fd.job.rel, err)
 		}
 	}
 
-	r.Close()
-	if err := w.Close(); err != nil {
-		tempFile.Close()
-		os.Remove(tempPath)
-		return err
-	}
-	if err := tempFile.Close(); err != nil {
-		os.Remove(tempPath)
-		return err
-	}
-
-	// Replace original with temp
-	if err := os.Remove(zipPath); err != nil {
-		return err
+	if fd.job.isRegular() && !fd.stream {
+		// The file may have changed size since it was scanned
+		header.Size = int64(len(fd.data))
 	}
-	return os.Rename(tempPath, zipPath)
-}
 
-// copyZipFile copies a file from one zip to another
-func copyZipFile(w *zip.Writer, f *zip.File) error {
-	fw, err := w.CreateHeader(&f.FileHeader)
-	if err != nil {
+	if err := tw.WriteHeader(header); err != nil {
 		return err
 	}
 
-	fr, err := f.Open()
-	if err != nil {
-		return err
+	if !fd.job.isRegular() {
+		return nil
 	}
-	defer fr.Close()
-
-	_, err = io.Copy(fw, fr)
-	return err
-}
 
-// writeChecksumFile writes checksum to a .sha256 file
-func writeChecksumFile(archivePath, checksum string) error {
-	checksumPath := archivePath + ".sha256"
-	content := fmt.Sprintf("%s *%s\n", checksum, filepath.Base(archivePath))
-	return os.WriteFile(checksumPath, []byte(content), 0644)
-}
-
-// VerifyChecksum verifies the checksum of an archive
-func VerifyChecksum(archivePath string) (bool, string, error) {
-	ext := strings.ToLower(filepath.Ext(archivePath))
-
-	if ext == ".zip" {
-		// Read checksum from zip comment
-		r, err := zip.OpenReader(archivePath)
-		if err != nil {
-			return false, "", err
-		}
-		defer r.Close()
-
-		comment := r.Comment
-		if !strings.HasPrefix(comment, "SHA256: ") {
-			return false, "", fmt.Errorf("no checksum found in archive")
-		}
-
-		storedChecksum := strings.TrimPrefix(comment, "SHA256: ")
-		actualChecksum, err := calculateFileChecksum(archivePath)
+	if src != nil {
+		// tar needs the size up front, so never copy more than the header promised
+		limited := &limitedWriter{w: tw, n: header.Size}
+		written, err := streamFile(limited, src, addDone)
 		if err != nil {
-			return false, "", err
+			return fmt.Errorf("%s: %w", fd.job.rel, err)
 		}
-
-		return storedChecksum == actualChecksum, storedChecksum, nil
-	} else if ext == ".gz" || strings.HasSuffix(archivePath, ".tar.gz") {
-		// Read from .sha256 file
-		checksumPath := archivePath + ".sha256"
-		data, err := os.ReadFile(checksumPath)
-		if err != nil {
-			return false, "", fmt.Errorf("checksum file not found: %w", err)
+		if written < header.Size {
+			return fmt.Errorf("%s: file shrank while archiving", fd.job.rel)
 		}
-
-		parts := strings.Fields(string(data))
-		if len(parts) < 1 {
-			return false, "", fmt.Errorf("invalid checksum file format")
-		}
-
-		storedChecksum := parts[0]
-		actualChecksum, err := calculateFileChecksum(archivePath)
-		if err != nil {
-			return false, "", err
-		}
-
-		return storedChecksum == actualChecksum, storedChecksum, nil
+		return nil
 	}
 
-	return false, "", fmt.Errorf("unsupported archive format")
+	if _, err := tw.Write(fd.data); err != nil {
+		return err
+	}
+	addDone(int64(len(fd.data)))
+	return nil
 }
@@ -0,0 +1,39 @@
+package zipper
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// getOptimalZstdLevel picks the zstd level for totalSize using the same size
+// tiers as getOptimalCompressionLevel: the best ratio for small archives and
+// the fastest level for very large ones.
+func getOptimalZstdLevel(totalSize int64) zstd.EncoderLevel {
+	const MB = 1024 * 1024
+	switch {
+	case totalSize < 10*MB:
+		return zstd.SpeedBestCompression // Small files: max compression
+	case totalSize < 100*MB:
+		return zstd.SpeedBetterCompression // Medium: balanced
+	case totalSize < 500*MB:
+		return zstd.SpeedDefault // Large: favor speed
+	default:
+		return zstd.SpeedFastest // Very large: maximum speed
+	}
+}
+
+// newZstdWriter returns a zstd writer for a stream of about totalSize bytes
+// that compresses blocks of the frame on every core.
+func newZstdWriter(w io.Writer, totalSize int64) (io.WriteCloser, error) {
+	return zstd.NewWriter(w,
+		zstd.WithEncoderLevel(getOptimalZstdLevel(totalSize)),
+		zstd.WithEncoderConcurrency(getCompressorCount()),
+	)
+}
+
+// ZstdFiles creates a tar.zst archive of any number of files and directories,
+// naming entries the same way as ZipFiles.
+func ZstdFiles(sources []string, zstPath string, opts CreateOptions) (stats ArchiveStats, err error) {
+	return writeTarArchive(sources, zstPath, opts, newZstdWriter)
+}